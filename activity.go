@@ -0,0 +1,210 @@
+package bitfit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// GoalPeriod is the period of time over which an activity goal is set.
+type GoalPeriod string
+
+const (
+	Daily  GoalPeriod = "daily"
+	Weekly GoalPeriod = "weekly"
+)
+
+// ActivityGoals are the targets of activity for a period, where distance
+// is in the units of the user's locale. Weekly goals only include distance,
+// floors, steps, and active zone minutes.
+type ActivityGoals struct {
+	Steps             uint
+	Distance          float64
+	Floors            uint
+	CaloriesOut       uint
+	ActiveMinutes     uint
+	ActiveZoneMinutes uint
+}
+
+func (a ActivityGoals) form() url.Values {
+	form := url.Values{}
+	setUint := func(k string, i uint) {
+		if i != 0 {
+			form.Set(k, strconv.FormatUint(uint64(i), 10))
+		}
+	}
+	setUint("steps", a.Steps)
+	if a.Distance != 0 {
+		form.Set("distance", strconv.FormatFloat(a.Distance, 'f', -1, 64))
+	}
+	setUint("floors", a.Floors)
+	setUint("caloriesOut", a.CaloriesOut)
+	setUint("activeMinutes", a.ActiveMinutes)
+	setUint("activeZoneMinutes", a.ActiveZoneMinutes)
+	return form
+}
+
+func (c *Client) FetchActivityGoals(p GoalPeriod) (ActivityGoals, error) {
+	s := apiURL("1/user/-/activities/goals/%v.json")
+	j := struct {
+		Goals ActivityGoals
+	}{}
+	if err := c.fetchInto(fmt.Sprintf(s, p), &j); err != nil {
+		return ActivityGoals{}, err
+	}
+	return j.Goals, nil
+}
+
+// UpdateActivityGoals sets the non-zero goals provided for the period and
+// returns all of the goals of that period as they were saved.
+func (c *Client) UpdateActivityGoals(p GoalPeriod, g ActivityGoals) (ActivityGoals, error) {
+	form := g.form()
+	if len(form) == 0 {
+		return ActivityGoals{}, errors.New("no activity goals to update were provided")
+	}
+	s := apiURL("1/user/-/activities/goals/%v.json")
+	j := struct {
+		Goals ActivityGoals
+	}{}
+	if err := c.sendInto("POST", fmt.Sprintf(s, p), form, &j); err != nil {
+		return ActivityGoals{}, err
+	}
+	return j.Goals, nil
+}
+
+type Badge struct {
+	ID            string
+	Type          string
+	Category      string
+	Name          string
+	ShortName     string
+	Description   string
+	EarnedMessage string
+	Date          time.Time
+	TimesAchieved uint
+	Value         float64
+	Unit          string
+	Image         string
+}
+
+func (b *Badge) UnmarshalJSON(data []byte) error {
+	j := struct {
+		EncodedID     string
+		BadgeType     string
+		Category      string
+		Name          string
+		ShortName     string
+		Description   string
+		EarnedMessage string
+		DateTime      string
+		TimesAchieved uint
+		Value         float64
+		Unit          string
+		Image100px    string
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	b.ID = j.EncodedID
+	b.Type = j.BadgeType
+	b.Category = j.Category
+	b.Name = j.Name
+	b.ShortName = j.ShortName
+	b.Description = j.Description
+	b.EarnedMessage = j.EarnedMessage
+	b.TimesAchieved = j.TimesAchieved
+	b.Value = j.Value
+	b.Unit = j.Unit
+	b.Image = j.Image100px
+	var err error
+	b.Date, err = parseDateInNYC(j.DateTime)
+	return err
+}
+
+func (c *Client) FetchBadges() ([]Badge, error) {
+	j := struct {
+		Badges []Badge
+	}{}
+	if err := c.fetchInto(apiURL("1/user/-/badges.json"), &j); err != nil {
+		return nil, err
+	}
+	return j.Badges, nil
+}
+
+// LifetimeTotals are the sums of activity since the user joined, where
+// distance is in kilometers or miles depending on the user's locale.
+type LifetimeTotals struct {
+	Steps       float64
+	Distance    float64
+	Floors      float64
+	CaloriesOut float64
+}
+
+// Record is the best value of an activity and the date it was achieved.
+type Record struct {
+	Date  time.Time
+	Value float64
+}
+
+func (r *Record) UnmarshalJSON(data []byte) error {
+	j := struct {
+		Date  string
+		Value float64
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	r.Value = j.Value
+	var err error
+	r.Date, err = parseDateInNYC(j.Date)
+	return err
+}
+
+// Records are the user's bests, any of which are nil if never recorded.
+type Records struct {
+	Steps    *Record
+	Distance *Record
+	Floors   *Record
+}
+
+// LifetimeStats are activity totals and records since the user joined,
+// both including manually logged activities (Total) and only including
+// that recorded by a tracker (Tracker).
+type LifetimeStats struct {
+	Total       LifetimeTotals
+	Tracker     LifetimeTotals
+	BestTotal   Records
+	BestTracker Records
+}
+
+func (l *LifetimeStats) UnmarshalJSON(data []byte) error {
+	j := struct {
+		Lifetime struct {
+			Total   LifetimeTotals
+			Tracker LifetimeTotals
+		}
+		Best struct {
+			Total   Records
+			Tracker Records
+		}
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	l.Total = j.Lifetime.Total
+	l.Tracker = j.Lifetime.Tracker
+	l.BestTotal = j.Best.Total
+	l.BestTracker = j.Best.Tracker
+	return nil
+}
+
+func (c *Client) FetchLifetimeStats() (LifetimeStats, error) {
+	l := LifetimeStats{}
+	if err := c.fetchInto(apiURL("1/user/-/activities.json"), &l); err != nil {
+		return l, err
+	}
+	return l, nil
+}
@@ -0,0 +1,52 @@
+package bitfit
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestUnmarshallingLifetimeStats(t *testing.T) {
+	b := []byte(`{
+		"best": {
+			"total": {
+				"distance": {"date": "2019-06-01", "value": 21.3},
+				"steps": {"date": "2019-06-01", "value": 28143}
+			},
+			"tracker": {
+				"steps": {"date": "2019-05-11", "value": 25001}
+			}
+		},
+		"lifetime": {
+			"total": {"activeScore": -1, "caloriesOut": -1, "distance": 8521.4, "floors": 4210, "steps": 11205340},
+			"tracker": {"activeScore": -1, "caloriesOut": -1, "distance": 8301.2, "floors": 4210, "steps": 10980112}
+		}
+	}`)
+	l := new(LifetimeStats)
+	if err := json.Unmarshal(b, l); err != nil {
+		t.Fatal(err)
+	}
+	errFmt := "expected %v but received %v"
+	if e, a := 11205340.0, l.Total.Steps; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := 8301.2, l.Tracker.Distance; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := 28143.0, l.BestTotal.Steps.Value; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := "2019-05-11", l.BestTracker.Steps.Date.Format("2006-01-02"); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if l.BestTracker.Floors != nil {
+		t.Fatalf("expected no floors record but received %+v", l.BestTracker.Floors)
+	}
+}
+
+func TestEncodingActivityGoals(t *testing.T) {
+	f := ActivityGoals{Steps: 12000, Distance: 8.05}.form()
+	errFmt := "expected %v but received %v"
+	if e, a := "distance=8.05&steps=12000", f.Encode(); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+}
@@ -9,9 +9,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/peterbourgon/ff"
@@ -49,7 +51,13 @@ func (a Args) Validate() error {
 }
 
 func ParseFlagSet(fs *flag.FlagSet) error {
-	return ff.Parse(fs, os.Args[1:],
+	return ParseFlagSetArgs(fs, os.Args[1:])
+}
+
+// ParseFlagSetArgs is like ParseFlagSet but parses the arguments provided
+// instead of those of the program, e.g. to parse those of a subcommand.
+func ParseFlagSetArgs(fs *flag.FlagSet, args []string) error {
+	return ff.Parse(fs, args,
 		ff.WithConfigFileFlag(ConfigFlagName),
 		ff.WithConfigFileParser(ff.JSONParser),
 		ff.WithEnvVarPrefix("BIT_FIT"),
@@ -70,6 +78,10 @@ type Tokens struct {
 	Access     string
 	Refresh    string
 	Expiration time.Time
+	// Scopes are those granted when the user authorized the application,
+	// which are unknown (empty) for tokens serialized before they were kept.
+	Scopes []string
+	UserID string
 }
 
 // UnmarshalJSON accepts a JSON payload from the REST API
@@ -81,11 +93,15 @@ func (t *Tokens) UnmarshalJSON(data []byte) error {
 		Access_token  string
 		Refresh_token string
 		Expires_in    uint
+		Scope         string
+		User_id       string
 		Errors        []map[string]string
 		// Tokens fields
 		Access     string
 		Refresh    string
 		Expiration time.Time
+		Scopes     []string
+		UserID     string
 	}{}
 	if err := json.Unmarshal(data, &s); err != nil {
 		return err
@@ -121,12 +137,37 @@ func (t *Tokens) UnmarshalJSON(data []byte) error {
 		}
 		t.Expiration = time.Now().Add(d)
 	}
+	switch {
+	case len(s.Scopes) > 0:
+		t.Scopes = s.Scopes
+	case s.Scope != "":
+		t.Scopes = strings.Fields(s.Scope)
+	}
+	switch {
+	case s.UserID != "":
+		t.UserID = s.UserID
+	case s.User_id != "":
+		t.UserID = s.User_id
+	}
 	return nil
 }
 
+// HasScope reports whether the scope was granted, or if the granted
+// scopes are unknown.
+func (t Tokens) HasScope(scope string) bool {
+	if len(t.Scopes) == 0 {
+		return true
+	}
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
 func FetchTokensPayload(id, secret, refreshToken string) ([]byte, error) {
-	s := fmt.Sprintf("%v:%v", id, secret)
-	s = fmt.Sprintf("Basic %v", base64.StdEncoding.EncodeToString([]byte(s)))
+	s := basicAuth(id, secret)
 	payload := fmt.Sprintf("grant_type=refresh_token&refresh_token=%v", refreshToken)
 	url := apiURL("oauth2/token")
 
@@ -149,6 +190,11 @@ func FetchTokensPayload(id, secret, refreshToken string) ([]byte, error) {
 	return format(b)
 }
 
+func basicAuth(id, secret string) string {
+	s := fmt.Sprintf("%v:%v", id, secret)
+	return fmt.Sprintf("Basic %v", base64.StdEncoding.EncodeToString([]byte(s)))
+}
+
 var BaseURL = "https://api.fitbit.com"
 
 func apiURL(uri string) string {
@@ -185,6 +231,17 @@ type Client struct {
 	initialized    bool
 	Initializer    func() (bool, error)
 	Authorizer     func(r *http.Request) error
+	// TokensLoader and TokensSaver load the tokens on initialization and
+	// save them once refreshed, from and to the tokens file by default.
+	TokensLoader func() (Tokens, error)
+	TokensSaver  func(Tokens) error
+	// Refreshed, if set, is called after each attempt to refresh the tokens
+	// with the refreshed tokens or the error of the attempt. It is called
+	// while the tokens are guarded, so must not call the client's methods.
+	Refreshed func(t Tokens, err error)
+	// mu guards the tokens while they are refreshed, and the rate limit.
+	mu        sync.Mutex
+	rateLimit RateLimit
 }
 
 func NewClient(id, secret, tokensFilepath string) *Client {
@@ -203,6 +260,8 @@ func NewClient(id, secret, tokensFilepath string) *Client {
 	}
 	c.Authorizer = c.authorizeWithOAuth2
 	c.Initializer = c.init
+	c.TokensLoader = c.loadTokensFile
+	c.TokensSaver = c.saveTokensFile
 	return c
 }
 
@@ -212,45 +271,93 @@ func (c *Client) Init() (err error) {
 }
 
 func (c *Client) init() (initialized bool, err error) {
+	t, err := c.TokensLoader()
+	if err != nil {
+		return false, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Tokens = t
+	if c.Expiration.Before(time.Now()) {
+		if err := c.refreshTokens(); err != nil {
+			s := "could not refresh expired tokens loaded from '%v' during Init func: %v"
+			return false, fmt.Errorf(s, err)
+		}
+	}
+	return true, nil
+}
+
+// tokens returns the client's tokens, guarded from being refreshed.
+func (c *Client) tokens() Tokens {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Tokens
+}
+
+// SaveTokens saves the client's tokens with its TokensSaver, waiting for
+// any refresh in progress, such as to be sure they are saved before exiting.
+func (c *Client) SaveTokens() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.TokensSaver(c.Tokens)
+}
+
+// SetTokens replaces and saves the client's tokens, such as with those of
+// the user authorizing the application again once the refresh token is no
+// longer valid, without interrupting the use of the client.
+func (c *Client) SetTokens(t Tokens) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Tokens = t
+	return c.TokensSaver(t)
+}
+
+// CurrentTokens returns the client's tokens, as of their latest refresh,
+// such as to check when they expire while the client is in use.
+func (c *Client) CurrentTokens() Tokens {
+	return c.tokens()
+}
+
+func (c *Client) loadTokensFile() (Tokens, error) {
 	if c.tokensFilepath == "" {
 		s := "filepath of an existing token (serialized as JSON) must be set on Client"
-		return false, fmt.Errorf(s)
+		return Tokens{}, fmt.Errorf(s)
 	}
 	b, err := ioutil.ReadFile(c.tokensFilepath)
 	if err != nil {
 		s := "filepath of tokens '%v' could not be read: %v"
-		return false, fmt.Errorf(s, c.tokensFilepath, err)
+		return Tokens{}, fmt.Errorf(s, c.tokensFilepath, err)
 	}
 	var t Tokens
 	if err := json.Unmarshal(b, &t); err != nil {
 		s := "could not unmarshal tokens at filepath '%v': %v"
-		return false, fmt.Errorf(s, c.tokensFilepath, err)
-	}
-	c.Tokens = t
-	if c.Expiration.Before(time.Now()) {
-		if err := c.refreshTokens(); err != nil {
-			s := "could not refresh expired tokens loaded from '%v' during Init func: %v"
-			return false, fmt.Errorf(s, err)
-		}
+		return Tokens{}, fmt.Errorf(s, c.tokensFilepath, err)
 	}
-	return true, nil
+	return t, nil
 }
 
 func (c *Client) RoundTrip(req *http.Request) (*http.Response, error) {
 	if err := c.Authorizer(req); err != nil {
 		return nil, err
 	}
-	return http.DefaultTransport.RoundTrip(req)
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	c.recordRateLimit(resp.Header)
+	return resp, nil
 }
 
 func (c *Client) authorizeWithOAuth2(req *http.Request) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.shouldRefreshTokens() {
 		if err := c.refreshTokens(); err != nil {
 			s := "could not refresh expired tokens before request in round trip function: %v"
 			return fmt.Errorf(s, err)
 		}
 	}
-	req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", c.Access))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", c.Access))
 	return nil
 }
 
@@ -258,17 +365,25 @@ func (c *Client) shouldRefreshTokens() bool {
 	return c.Expiration.Before(time.Now().Add(10 * time.Minute))
 }
 
+// refreshTokens refreshes and saves the tokens, and must be called with
+// the tokens guarded.
 func (c *Client) refreshTokens() error {
 	t, err := FetchTokens(c.id, c.secret, c.Refresh)
+	if c.Refreshed != nil {
+		c.Refreshed(t, err)
+	}
 	if err != nil {
 		return err
 	}
+	if t.UserID == "" {
+		t.UserID = c.UserID
+	}
 	c.Tokens = t
-	return c.saveTokens()
+	return c.TokensSaver(t)
 }
 
-func (c *Client) saveTokens() error {
-	b, err := json.Marshal(c.Tokens)
+func (c *Client) saveTokensFile(t Tokens) error {
+	b, err := json.Marshal(t)
 	if err != nil {
 		return fmt.Errorf("could not serialize tokens: %v", err)
 	}
@@ -284,6 +399,9 @@ func (c *Client) saveTokens() error {
 }
 
 func (c *Client) fetch(url string) (respBody []byte, err error) {
+	if err := c.checkScope(url); err != nil {
+		return []byte{}, err
+	}
 	resp, err := c.Get(url)
 	if err != nil {
 		return []byte{}, err
@@ -300,6 +418,81 @@ func (c *Client) fetch(url string) (respBody []byte, err error) {
 	return format(b)
 }
 
+// fetchInto fetches the resource at url and deserializes the JSON
+// response body into v.
+func (c *Client) fetchInto(url string, v interface{}) error {
+	b, err := c.fetch(url)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		s := "could not unmarshal response from '%s': %v"
+		return fmt.Errorf(s, url, err)
+	}
+	return nil
+}
+
+// send makes a request with the given method to rawURL, encoding any
+// form values as the request body, as the Fitbit API expects of POSTs.
+func (c *Client) send(method, rawURL string, form url.Values) (respBody []byte, err error) {
+	req, err := newFormRequest(method, rawURL, form)
+	if err != nil {
+		return []byte{}, err
+	}
+	return c.do(req)
+}
+
+func newFormRequest(method, rawURL string, form url.Values) (*http.Request, error) {
+	req, err := http.NewRequest(method, rawURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	if len(form) > 0 {
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	}
+	return req, nil
+}
+
+// do makes the request and returns the formatted response body, or an
+// error if the response status is not one of success.
+func (c *Client) do(req *http.Request) (respBody []byte, err error) {
+	if err := c.checkScope(req.URL.String()); err != nil {
+		return []byte{}, err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return []byte{}, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return []byte{}, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+	default:
+		s := "did not receive HTTP status OK (200), Created (201), or No Content (204) on %s to '%s' : %s"
+		return b, fmt.Errorf(s, req.Method, req.URL, resp.Status)
+	}
+	if len(b) == 0 {
+		return b, nil
+	}
+	return format(b)
+}
+
+// sendInto is like send but deserializes the JSON response body into v.
+func (c *Client) sendInto(method, rawURL string, form url.Values, v interface{}) error {
+	b, err := c.send(method, rawURL, form)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		s := "could not unmarshal response from %s to '%s': %v"
+		return fmt.Errorf(s, method, rawURL, err)
+	}
+	return nil
+}
+
 func (c *Client) FetchProfile() (respBody []byte, err error) {
 	url := apiURL("1/user/-/profile.json")
 	return c.fetch(url)
@@ -328,6 +521,13 @@ func FetchProfile() (respBody []byte, err error) {
 	return DefaultClient.FetchProfile()
 }
 
+func FetchUserProfile() (Profile, error) {
+	if !DefaultClient.initialized {
+		return Profile{}, errNotInitialized
+	}
+	return DefaultClient.FetchUserProfile()
+}
+
 func FetchSleepLog(from time.Time) (respBody []byte, err error) {
 	if !DefaultClient.initialized {
 		return errorInit()
@@ -335,8 +535,10 @@ func FetchSleepLog(from time.Time) (respBody []byte, err error) {
 	return DefaultClient.FetchSleepLog(from)
 }
 
+var errNotInitialized = errors.New("the package's init func must be called first")
+
 func errorInit() (empty []byte, err error) {
-	return []byte{}, errors.New("the package's init func must be called first")
+	return []byte{}, errNotInitialized
 }
 
 type SleepLog struct {
@@ -428,20 +630,27 @@ func (d *DurationPerStage) UnmarshalJSON(data []byte) error {
 }
 
 type Session struct {
+	DateOfSleep  time.Time
 	Start        time.Time
 	End          time.Time
 	Length       time.Duration
+	Asleep       time.Duration
 	IsPrimary    bool
 	Observations ByStartTime
+	// Physiology is only set on a primary session, and only once
+	// attached with the AttachPhysiology method of a SleepLog.
+	Physiology *Physiology
 }
 
 func (s *Session) UnmarshalJSON(data []byte) (err error) {
 	j := struct {
-		StartTime   string
-		EndTime     string
-		Duration    uint
-		IsMainSleep bool
-		Levels      struct {
+		DateOfSleep   string
+		StartTime     string
+		EndTime       string
+		Duration      uint
+		MinutesAsleep uint
+		IsMainSleep   bool
+		Levels        struct {
 			Data      []Observation
 			ShortData []Observation
 		}
@@ -450,12 +659,19 @@ func (s *Session) UnmarshalJSON(data []byte) (err error) {
 		return err
 	}
 	s.IsPrimary = j.IsMainSleep
+	if j.DateOfSleep != "" {
+		if s.DateOfSleep, err = parseDateInNYC(j.DateOfSleep); err != nil {
+			return err
+		}
+	}
 	if s.Start, err = parseInNYC(j.StartTime); err != nil {
 		return err
 	} else if s.End, err = parseInNYC(j.EndTime); err != nil {
 		return err
 	} else if s.Length, err = parseSec(j.Duration); err != nil {
 		return err
+	} else if s.Asleep, err = parseMin(j.MinutesAsleep); err != nil {
+		return err
 	}
 	s.Observations = make(ByStartTime, 0)
 	s.Observations = append(s.Observations, j.Levels.Data...)
@@ -469,9 +685,14 @@ func parseInNYC(s string) (time.Time, error) {
 	return time.ParseInLocation(zonelessTimeFmt, s, newYork)
 }
 
+func parseDateInNYC(s string) (time.Time, error) {
+	return time.ParseInLocation(dateFmt, s, newYork)
+}
+
 var (
 	newYork         *time.Location
 	zonelessTimeFmt = "2006-01-02T15:04:05.000"
+	dateFmt         = "2006-01-02"
 )
 
 func init() {
@@ -3,10 +3,28 @@ package bitfit
 import (
 	"encoding/json"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
 
+// newTestClient returns a Client with unexpired tokens that makes its
+// requests to a server using the handler h in place of the Fitbit API.
+func newTestClient(t *testing.T, h http.HandlerFunc) *Client {
+	srv := httptest.NewServer(h)
+	prev := BaseURL
+	BaseURL = srv.URL
+	t.Cleanup(func() {
+		BaseURL = prev
+		srv.Close()
+	})
+	c := NewClient("id", "secret", "")
+	c.Tokens = Tokens{Access: "foo", Refresh: "bar", Expiration: time.Now().Add(time.Hour)}
+	c.initialized = true
+	return c
+}
+
 func TestUnmarshallingTokensPayload(t *testing.T) {
 	b, err := ioutil.ReadFile("testdata/tokens_payload.json")
 	if err != nil {
@@ -30,6 +48,15 @@ func TestUnmarshallingTokensPayload(t *testing.T) {
 	if e, a := "bar", tt.Refresh; e != a {
 		t.Fatal(s, e, a)
 	}
+	if e, a := "BAZ", tt.UserID; e != a {
+		t.Fatalf(s, e, a)
+	}
+	if e, a := 9, len(tt.Scopes); e != a {
+		t.Fatalf(s, e, a)
+	}
+	if !tt.HasScope("sleep") || tt.HasScope("electrocardiogram") {
+		t.Fatalf("expected only granted scopes of %v", tt.Scopes)
+	}
 }
 
 func TestUnmarshallingTokens(t *testing.T) {
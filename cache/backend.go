@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// MemoryBackend stores entries in memory, up to a maximum number of them.
+type MemoryBackend struct {
+	mu      sync.Mutex
+	max     int
+	entries map[string]Entry
+}
+
+// NewMemoryBackend returns a MemoryBackend of at most max entries, or of
+// any number of them if max is 0.
+func NewMemoryBackend(max int) *MemoryBackend {
+	return &MemoryBackend{max: max, entries: make(map[string]Entry)}
+}
+
+func (m *MemoryBackend) Get(key string) (Entry, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	return e, ok, nil
+}
+
+// Set stores an entry, making room for it if the backend is full by
+// dropping expired entries, or else the entry that expires soonest.
+func (m *MemoryBackend) Set(key string, e Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.entries[key]; !ok && m.max > 0 && len(m.entries) >= m.max {
+		now := time.Now()
+		soonest := ""
+		for k, ee := range m.entries {
+			if ee.Expires.Before(now) {
+				delete(m.entries, k)
+			} else if soonest == "" || ee.Expires.Before(m.entries[soonest].Expires) {
+				soonest = k
+			}
+		}
+		if len(m.entries) >= m.max {
+			delete(m.entries, soonest)
+		}
+	}
+	m.entries[key] = e
+	return nil
+}
+
+func (m *MemoryBackend) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+// DirBackend stores each entry in a file of a directory, named by a hash
+// of its key, so that the cache outlasts the process.
+type DirBackend string
+
+func (d DirBackend) filepath(key string) string {
+	b := sha256.Sum256([]byte(key))
+	return filepath.Join(string(d), hex.EncodeToString(b[:])+".json")
+}
+
+func (d DirBackend) Get(key string) (Entry, bool, error) {
+	e := Entry{}
+	p := d.filepath(key)
+	b, err := ioutil.ReadFile(p)
+	switch {
+	case os.IsNotExist(err):
+		return e, false, nil
+	case err != nil:
+		return e, false, fmt.Errorf("could not read cache entry '%v': %v", p, err)
+	}
+	if err := json.Unmarshal(b, &e); err != nil {
+		return e, false, fmt.Errorf("could not unmarshal cache entry '%v': %v", p, err)
+	}
+	return e, true, nil
+}
+
+// Set writes the entry to a temporary file and renames it over any file
+// of the same key, so that an entry is never partially written.
+func (d DirBackend) Set(key string, e Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("could not serialize cache entry: %v", err)
+	}
+	if err := os.MkdirAll(string(d), 0700); err != nil {
+		return fmt.Errorf("could not make cache directory '%v': %v", d, err)
+	}
+	f, err := ioutil.TempFile(string(d), "tmp")
+	if err != nil {
+		return fmt.Errorf("could not create cache entry: %v", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return fmt.Errorf("could not write cache entry '%v': %v", f.Name(), err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("could not write cache entry '%v': %v", f.Name(), err)
+	}
+	p := d.filepath(key)
+	if err := os.Rename(f.Name(), p); err != nil {
+		return fmt.Errorf("could not save cache entry '%v': %v", p, err)
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+// Package cache caches the responses to GET requests of the Fitbit API,
+// so that repeated requests for the same data do not spend the hourly
+// rate limit. The data of past dates rarely changes once synced, so is
+// cached for long, while the data of today is only cached briefly.
+//
+// A Transport wraps a bitfit.Client, e.g.
+//
+//	c := bitfit.NewClient(id, secret, tokensFilepath)
+//	c.Client.Transport = cache.NewTransport(c, cache.NewMemoryBackend(0))
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var dateFmt = "2006-01-02"
+
+// Entry is a cached response.
+type Entry struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Expires    time.Time
+}
+
+// Backend stores entries by key. A Backend may drop entries at any time,
+// and need not drop them once expired since a Transport ignores those.
+type Backend interface {
+	Get(key string) (Entry, bool, error)
+	Set(key string, e Entry) error
+}
+
+// TTLs are how long responses are cached for, by the latest date of the
+// data requested, as found in the path (e.g. "/date/2019-09-16.json").
+type TTLs struct {
+	// Past is how long data of dates before yesterday is cached for.
+	Past time.Duration
+	// Yesterday is how long data of yesterday is cached for, which may still
+	// change as devices that were not synced yesterday are.
+	Yesterday time.Duration
+	// Today is how long data of today (or later) is cached for.
+	Today time.Duration
+	// Undated is how long data of no date (e.g. a profile) is cached for.
+	Undated time.Duration
+	// Paths overrides the TTL of requests to paths beginning with a key, of
+	// the longest key that any path begins with.
+	Paths map[string]time.Duration
+	// Location is that of the dates of the user. If nil, the user's today
+	// is taken to be any date within a day of today in time.Local, since
+	// it may be a day ahead or behind, and yesterday the day before those.
+	Location *time.Location
+}
+
+// DefaultTTLs cache the data of past dates for 30 days, that of yesterday
+// for an hour and that of today for 5 minutes, and do not cache undated data.
+var DefaultTTLs = TTLs{
+	Past:      30 * 24 * time.Hour,
+	Yesterday: time.Hour,
+	Today:     5 * time.Minute,
+}
+
+// TTL returns how long the response to a request to the path may be cached,
+// or 0 if it may not be.
+func (t TTLs) TTL(path string, now time.Time) time.Duration {
+	prefix := ""
+	for p := range t.Paths {
+		if strings.HasPrefix(path, p) && len(p) > len(prefix) {
+			prefix = p
+		}
+	}
+	if prefix != "" {
+		return t.Paths[prefix]
+	}
+	loc := t.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	y, m, d := now.In(loc).Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, loc)
+	if t.Location == nil {
+		today = today.AddDate(0, 0, -1)
+	}
+	var latest time.Time
+	for _, s := range strings.Split(path, "/") {
+		s = strings.TrimSuffix(s, ".json")
+		if s == "today" {
+			return t.Today
+		}
+		if d, err := time.ParseInLocation(dateFmt, s, loc); err == nil && d.After(latest) {
+			latest = d
+		}
+	}
+	switch {
+	case latest.IsZero():
+		return t.Undated
+	case !latest.Before(today):
+		return t.Today
+	case !latest.Before(today.AddDate(0, 0, -1)):
+		return t.Yesterday
+	}
+	return t.Past
+}
+
+// Stats count the requests of a Transport.
+type Stats struct {
+	// Hits counts requests responded to from the cache.
+	Hits uint64
+	// Misses counts cacheable requests that were not in the cache.
+	Misses uint64
+	// Errors counts errors of the backend.
+	Errors uint64
+}
+
+// HitHeader is set on responses to "HIT" if responded to from the cache
+// and "MISS" otherwise.
+const HitHeader = "X-Cache"
+
+// Transport responds to GET requests with cached responses, and otherwise
+// makes them with Next, caching any OK response for the TTL of its path.
+type Transport struct {
+	Next    http.RoundTripper
+	Backend Backend
+	TTLs    TTLs
+	// Key returns the key of a request in the backend, which is as returned
+	// by Key of the request's Authorization header (if any) by default.
+	// Responses that differ by who made a request (e.g. by their tokens)
+	// must have different keys, so a Transport that wraps a bitfit.Client
+	// (which authorizes requests after they are cached) must not share its
+	// backend with those of other accounts unless its keys tell them apart.
+	Key   func(r *http.Request) string
+	stats Stats
+	now   func() time.Time
+}
+
+// VaryHeaders are the headers of a request by which the Fitbit API varies
+// its response, i.e. by the units and locale of the data.
+var VaryHeaders = []string{"Accept-Language", "Accept-Locale"}
+
+// Key returns a key of a request of an account, by its URL and VaryHeaders,
+// that may be used as the Key func of a Transport and to identify requests
+// that have the same response.
+func Key(account string, r *http.Request) string {
+	k := account + " " + r.URL.String()
+	for _, h := range VaryHeaders {
+		k += "\n" + h + ": " + strings.Join(r.Header.Values(h), ", ")
+	}
+	return k
+}
+
+func NewTransport(next http.RoundTripper, b Backend) *Transport {
+	return &Transport{
+		Next:    next,
+		Backend: b,
+		TTLs:    DefaultTTLs,
+		Key: func(r *http.Request) string {
+			account := ""
+			if a := r.Header.Get("Authorization"); a != "" {
+				sum := sha256.Sum256([]byte(a))
+				account = hex.EncodeToString(sum[:])
+			}
+			return Key(account, r)
+		},
+		now: time.Now,
+	}
+}
+
+// Stats returns the counts of the transport's requests so far.
+func (t *Transport) Stats() Stats {
+	return Stats{
+		Hits:   atomic.LoadUint64(&t.stats.Hits),
+		Misses: atomic.LoadUint64(&t.stats.Misses),
+		Errors: atomic.LoadUint64(&t.stats.Errors),
+	}
+}
+
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	now := t.now()
+	ttl := t.TTLs.TTL(r.URL.Path, now)
+	if r.Method != "GET" || ttl <= 0 {
+		return t.Next.RoundTrip(r)
+	}
+	key := t.Key(r)
+	e, ok, err := t.Backend.Get(key)
+	if err != nil {
+		atomic.AddUint64(&t.stats.Errors, 1)
+	}
+	if ok && now.Before(e.Expires) {
+		atomic.AddUint64(&t.stats.Hits, 1)
+		return e.response(r, "HIT"), nil
+	}
+	atomic.AddUint64(&t.stats.Misses, 1)
+	resp, err := t.Next.RoundTrip(r)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not read response to cache from '%v': %v", r.URL, err)
+	}
+	e = Entry{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header.Clone(),
+		Body:       b,
+		Expires:    now.Add(ttl),
+	}
+	// The rate limit of a cached response is not that of when it is hit.
+	for k := range e.Header {
+		if strings.HasPrefix(k, "Fitbit-Rate-Limit") {
+			e.Header.Del(k)
+		}
+	}
+	if err := t.Backend.Set(key, e); err != nil {
+		atomic.AddUint64(&t.stats.Errors, 1)
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+	resp.Header.Set(HitHeader, "MISS")
+	return resp, nil
+}
+
+func (e Entry) response(r *http.Request, hit string) *http.Response {
+	h := e.Header.Clone()
+	if h == nil {
+		h = make(http.Header)
+	}
+	h.Set(HitHeader, hit)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode)),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       r,
+	}
+}
@@ -0,0 +1,170 @@
+package cache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTTLs(t *testing.T) {
+	nyc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ttls := DefaultTTLs
+	ttls.Location = nyc
+	ttls.Paths = map[string]time.Duration{"/1/user/-/profile.json": time.Hour}
+	// The 17th in New York, but the 18th in UTC.
+	now := time.Date(2019, 9, 18, 2, 0, 0, 0, time.UTC)
+	errFmt := "expected %v for '%v' but received %v"
+	tests := []struct {
+		path string
+		ttl  time.Duration
+	}{
+		{"/1.2/user/-/sleep/date/2019-09-15.json", ttls.Past},
+		{"/1.2/user/-/sleep/date/2019-09-16.json", ttls.Yesterday},
+		{"/1.2/user/-/sleep/date/2019-09-17.json", ttls.Today},
+		{"/1.2/user/-/sleep/date/2019-09-01/2019-09-16.json", ttls.Yesterday},
+		{"/1/user/-/activities/heart/date/today/1d.json", ttls.Today},
+		{"/1/user/-/devices.json", ttls.Undated},
+		{"/1/user/-/profile.json", time.Hour},
+	}
+	for _, tt := range tests {
+		if e, a := tt.ttl, ttls.TTL(tt.path, now); e != a {
+			t.Fatalf(errFmt, e, tt.path, a)
+		}
+	}
+}
+
+func TestTTLsOfUnknownLocation(t *testing.T) {
+	ttls := DefaultTTLs
+	now := time.Date(2019, 9, 17, 12, 0, 0, 0, time.Local)
+	errFmt := "expected %v for '%v' but received %v"
+	// The user's today may be a day either side of the 17th.
+	for path, e := range map[string]time.Duration{
+		"/1.2/user/-/sleep/date/2019-09-14.json": ttls.Past,
+		"/1.2/user/-/sleep/date/2019-09-15.json": ttls.Yesterday,
+		"/1.2/user/-/sleep/date/2019-09-16.json": ttls.Today,
+		"/1.2/user/-/sleep/date/2019-09-17.json": ttls.Today,
+		"/1.2/user/-/sleep/date/2019-09-18.json": ttls.Today,
+	} {
+		if a := ttls.TTL(path, now); e != a {
+			t.Fatalf(errFmt, e, path, a)
+		}
+	}
+}
+
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func testTransport(t *testing.T, b Backend) {
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Fitbit-Rate-Limit-Remaining", "149")
+		fmt.Fprintf(w, "request %d", requests)
+	}))
+	defer s.Close()
+	tr := NewTransport(http.DefaultTransport, b)
+	now := time.Date(2019, 9, 18, 12, 0, 0, 0, time.Local)
+	tr.now = func() time.Time { return now }
+	c := &http.Client{Transport: tr}
+	get := func(path string) (string, *http.Response) {
+		resp, err := c.Get(s.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body), resp
+	}
+	errFmt := "expected %v but received %v"
+	past := "/1.2/user/-/sleep/date/2019-09-01.json"
+	if body, resp := get(past); body != "request 1" || resp.Header.Get(HitHeader) != "MISS" {
+		t.Fatalf(errFmt, "request 1 (MISS)", body+" ("+resp.Header.Get(HitHeader)+")")
+	}
+	body, resp := get(past)
+	if e, a := "request 1", body; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := "HIT", resp.Header.Get(HitHeader); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if a := resp.Header.Get("Fitbit-Rate-Limit-Remaining"); a != "" {
+		t.Fatalf(errFmt, "no rate limit of a cached response", a)
+	}
+	if body, _ := get("/1/user/-/devices.json"); body != "request 2" {
+		t.Fatalf(errFmt, "request 2", body)
+	}
+	now = now.Add(31 * 24 * time.Hour)
+	if body, _ := get(past); body != "request 3" {
+		t.Fatalf(errFmt, "request 3", body)
+	}
+	if e, a := (Stats{Hits: 1, Misses: 2}), tr.Stats(); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	testTransport(t, NewMemoryBackend(0))
+
+	m := NewMemoryBackend(2)
+	now := time.Now()
+	for i, d := range []time.Duration{time.Hour, time.Minute, 2 * time.Hour} {
+		if err := m.Set(fmt.Sprint(i), Entry{Expires: now.Add(d)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errFmt := "expected %v but received %v"
+	if e, a := 2, m.Len(); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if _, ok, _ := m.Get("1"); ok {
+		t.Fatal("expected the entry that expires soonest to be dropped")
+	}
+}
+
+func TestDirBackend(t *testing.T) {
+	testTransport(t, DirBackend(t.TempDir()))
+}
+
+func TestKey(t *testing.T) {
+	tr := NewTransport(nil, nil)
+	req := func(authorization, language string) *http.Request {
+		r := httptest.NewRequest("GET", "https://api.fitbit.com/1/user/-/body/log/weight/date/2019-09-16.json", nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		if language != "" {
+			r.Header.Set("Accept-Language", language)
+		}
+		return r
+	}
+	tests := []struct {
+		a, b *http.Request
+		same bool
+	}{
+		{req("Bearer foo", ""), req("Bearer foo", ""), true},
+		{req("Bearer foo", ""), req("Bearer bar", ""), false},
+		{req("Bearer foo", ""), req("Bearer foo", "en_US"), false},
+		{req("", "en_US"), req("", "en_GB"), false},
+	}
+	for i, tt := range tests {
+		if e, a := tt.same, tr.Key(tt.a) == tr.Key(tt.b); e != a {
+			t.Fatalf("expected keys of requests of test %d to be the same (%v) but received %v", i, e, a)
+		}
+	}
+	if k := tr.Key(req("Bearer foo", "")); strings.Contains(k, "foo") {
+		t.Fatalf("expected the key not to contain the token but received %v", k)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -17,9 +18,13 @@ func main() {
 	if err := bitfit.Init(*args.ClientID, *args.Secret, *args.TokensFilepath); err != nil {
 		log.Fatal(err)
 	}
-	b, err := bitfit.FetchProfile()
+	p, err := bitfit.FetchUserProfile()
 	if err != nil {
 		log.Fatal(err)
 	}
+	b, err := json.MarshalIndent(p, "", "    ")
+	if err != nil {
+		log.Fatalf("could not serialize profile: %v", err)
+	}
 	fmt.Println(string(b))
 }
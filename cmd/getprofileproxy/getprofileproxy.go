@@ -15,12 +15,19 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	switch "" {
-	case *args.BaseURL, *args.Username, *args.Password:
+	switch {
+	case *args.BaseURL == "":
 		flag.Usage()
 		os.Exit(1)
+	case *args.Key != "":
+		err = proxy.InitWithKey(*args.BaseURL, *args.Key)
+	case *args.Username == "", *args.Password == "":
+		flag.Usage()
+		os.Exit(1)
+	default:
+		err = proxy.Init(*args.BaseURL, *args.Username, *args.Password)
 	}
-	if err := proxy.Init(*args.BaseURL, *args.Username, *args.Password); err != nil {
+	if err != nil {
 		log.Fatal(err)
 	}
 	b, err := bitfit.FetchProfile()
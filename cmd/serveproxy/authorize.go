@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aoeu/bitfit"
+)
+
+// defaultScopes are those the Fitbit API is authorized with at /authorize
+// unless others are configured.
+var defaultScopes = "activity heartrate location nutrition profile settings sleep social weight"
+
+// maxPendingAuthorizations bounds the authorizations begun at /authorize
+// that have yet to be called back, each of which expires after
+// authorizationTimeout.
+const (
+	maxPendingAuthorizations = 16
+	authorizationTimeout     = 10 * time.Minute
+)
+
+// authorizer completes the authorization-code flow of the Fitbit API on
+// behalf of an admin, so that tokens whose refresh token is no longer valid
+// can be replaced while the proxy runs: /authorize redirects the admin to
+// authorize the application, and /callback exchanges the code the admin is
+// redirected back with for the tokens the proxy then uses.
+type authorizer struct {
+	Admin User
+	ID    string
+	// RedirectURL is the URL of /callback as registered with the Fitbit
+	// application, or else that of the host of each request to /authorize.
+	RedirectURL string
+	Scopes      []string
+	// Exchange exchanges a code for tokens, with the Fitbit API by default.
+	Exchange func(code, redirectURL, codeVerifier string) (bitfit.Tokens, error)
+	mu       sync.Mutex
+	pending  map[string]pendingAuthorization
+	now      func() time.Time
+}
+
+// pendingAuthorization is an authorization begun at /authorize, by its
+// state, that has yet to be called back.
+type pendingAuthorization struct {
+	codeVerifier string
+	redirectURL  string
+	expires      time.Time
+}
+
+func newAuthorizer(admin User, id, secret string) *authorizer {
+	return &authorizer{
+		Admin:  admin,
+		ID:     id,
+		Scopes: strings.Fields(defaultScopes),
+		Exchange: func(code, redirectURL, codeVerifier string) (bitfit.Tokens, error) {
+			return bitfit.ExchangeCode(id, secret, code, redirectURL, codeVerifier)
+		},
+		pending: make(map[string]pendingAuthorization),
+		now:     time.Now,
+	}
+}
+
+// begin begins an authorization that is redirected back to the URL, and
+// returns the URL at which to authorize the application.
+func (a *authorizer) begin(redirectURL string) (string, error) {
+	state, verifier := make([]byte, 24), make([]byte, 32)
+	for _, b := range [][]byte{state, verifier} {
+		if _, err := rand.Read(b); err != nil {
+			return "", fmt.Errorf("could not generate state of authorization: %v", err)
+		}
+	}
+	p := pendingAuthorization{
+		codeVerifier: base64.RawURLEncoding.EncodeToString(verifier),
+		redirectURL:  redirectURL,
+		expires:      a.now().Add(authorizationTimeout),
+	}
+	s := base64.RawURLEncoding.EncodeToString(state)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k, pp := range a.pending {
+		if !a.now().Before(pp.expires) {
+			delete(a.pending, k)
+		}
+	}
+	if len(a.pending) >= maxPendingAuthorizations {
+		return "", fmt.Errorf("too many authorizations are in progress, try again later")
+	}
+	a.pending[s] = p
+	return bitfit.AuthCodeURL(a.ID, redirectURL, s, p.codeVerifier, a.Scopes), nil
+}
+
+// complete completes the authorization of the state, which can only be
+// completed once and before it expires.
+func (a *authorizer) complete(state string) (pendingAuthorization, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p, ok := a.pending[state]
+	delete(a.pending, state)
+	return p, ok && a.now().Before(p.expires)
+}
+
+// callbackURL returns the URL of /callback of the host a request was made to.
+func callbackURL(r *http.Request) string {
+	scheme := "https"
+	if r.TLS == nil {
+		scheme = "http"
+	}
+	return scheme + "://" + r.Host + "/callback"
+}
+
+// handleAuthorize redirects the admin to authorize the application with the
+// Fitbit API.
+func (s *server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	a := s.authorizer
+	if !s.authenticateAs(w, r, &a.Admin, "admin") {
+		return
+	}
+	redirectURL := a.RedirectURL
+	if redirectURL == "" {
+		redirectURL = callbackURL(r)
+	}
+	u, err := a.begin(redirectURL)
+	if err != nil {
+		writeResp(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+// handleCallback exchanges the code the admin was redirected back with for
+// tokens, which replace those of the account of the Fitbit user who
+// authorized the application.
+func (s *server) handleCallback(w http.ResponseWriter, r *http.Request) {
+	a := s.authorizer
+	if !s.authenticateAs(w, r, &a.Admin, "admin") {
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		msg := fmt.Sprintf("authorization was not granted: %v: %v", e, q.Get("error_description"))
+		writeResp(w, http.StatusBadRequest, redact(msg))
+		return
+	}
+	p, ok := a.complete(q.Get("state"))
+	if !ok {
+		writeResp(w, http.StatusBadRequest, "the state of the authorization is unknown or expired, authorize again at /authorize")
+		return
+	}
+	if q.Get("code") == "" {
+		writeResp(w, http.StatusBadRequest, "no authorization code was called back with")
+		return
+	}
+	t, err := a.Exchange(q.Get("code"), p.redirectURL, p.codeVerifier)
+	if err != nil {
+		writeResp(w, http.StatusBadGateway, redact(err.Error()))
+		return
+	}
+	label, err := s.swapTokens(t)
+	if err != nil {
+		s.auditLog.write(auditEntry{Time: time.Now().UTC(), Event: "authorization_failure", Caller: a.Admin.Username, Account: label, Remote: remoteHost(r), Error: redact(err.Error())})
+		writeResp(w, http.StatusConflict, redact(err.Error()))
+		return
+	}
+	s.auditLog.write(auditEntry{Time: time.Now().UTC(), Event: "authorization", Caller: a.Admin.Username, Account: label, Remote: remoteHost(r)})
+	writeResp(w, http.StatusOK, fmt.Sprintf("the tokens of account '%v' were replaced and are in use", label))
+}
+
+// swapTokens replaces the tokens of the account of the Fitbit user whose
+// tokens they are, in the account's running client if it has one, and
+// returns the label of the account. The single tokens file may only be
+// replaced by tokens of the same Fitbit user, if its user is known.
+func (s *server) swapTokens(t bitfit.Tokens) (string, error) {
+	label, loaded := accountLabel(t.UserID), false
+	if s.manager != nil {
+		for _, id := range s.manager.UserIDs() {
+			loaded = loaded || id == t.UserID
+		}
+	}
+	var err error
+	switch {
+	case s.manager == nil:
+		label = accountLabel("")
+		if prev := s.client.CurrentTokens().UserID; prev != "" && t.UserID != "" && prev != t.UserID {
+			ss := "the tokens are of Fitbit user '%v' rather than '%v' of the tokens file"
+			return label, fmt.Errorf(ss, t.UserID, prev)
+		}
+		err = s.client.SetTokens(t)
+	case loaded:
+		var c *bitfit.Client
+		if c, err = s.manager.Client(t.UserID); err == nil {
+			err = c.SetTokens(t)
+		}
+	default:
+		_, err = s.manager.Add(t)
+	}
+	if err == nil {
+		s.health.refreshed(label, nil)
+	}
+	return label, err
+}
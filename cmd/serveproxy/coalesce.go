@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// coalescer makes identical GET requests that are in flight at the same
+// time as one request, whose response is shared by each of them, so that
+// they spend the quota of only one request.
+type coalescer struct {
+	Next http.RoundTripper
+	Key  func(r *http.Request) string
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// call is a request in flight, whose response is set once done.
+type call struct {
+	done chan struct{}
+	resp *http.Response
+	body []byte
+	err  error
+}
+
+func newCoalescer(next http.RoundTripper, key func(r *http.Request) string) *coalescer {
+	return &coalescer{Next: next, Key: key, calls: make(map[string]*call)}
+}
+
+func (c *coalescer) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method != "GET" || r.Header.Get("Range") != "" {
+		return c.Next.RoundTrip(r)
+	}
+	key := c.Key(r)
+	c.mu.Lock()
+	if cl, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		select {
+		case <-cl.done:
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		}
+		if cl.err != nil && r.Context().Err() == nil {
+			// The request coalesced into may have been canceled by its caller,
+			// which is no reason for this one to fail.
+			return c.Next.RoundTrip(r)
+		}
+		return cl.response(r)
+	}
+	cl := &call{done: make(chan struct{})}
+	c.calls[key] = cl
+	c.mu.Unlock()
+
+	cl.resp, cl.err = c.Next.RoundTrip(r)
+	if cl.err == nil {
+		cl.body, cl.err = ioutil.ReadAll(cl.resp.Body)
+		cl.resp.Body.Close()
+	}
+	c.mu.Lock()
+	delete(c.calls, key)
+	c.mu.Unlock()
+	close(cl.done)
+	return cl.response(r)
+}
+
+// response returns a copy of the call's response to the request.
+func (cl *call) response(r *http.Request) (*http.Response, error) {
+	if cl.err != nil {
+		return nil, cl.err
+	}
+	resp := *cl.resp
+	resp.Header = cl.resp.Header.Clone()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(cl.body))
+	resp.Request = r
+	return &resp, nil
+}
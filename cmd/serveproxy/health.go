@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aoeu/bitfit"
+)
+
+// health is kept of the outcome of the latest requests to the Fitbit API
+// and refreshes of tokens of each account, so that the health of the proxy
+// can be reported without making any request that would spend quota.
+type health struct {
+	mu       sync.Mutex
+	accounts map[string]*accountHealth
+}
+
+type accountHealth struct {
+	lastSuccess  time.Time
+	lastFailure  time.Time
+	lastError    string
+	refreshError string
+}
+
+func newHealth() *health {
+	return &health{accounts: make(map[string]*accountHealth)}
+}
+
+func (h *health) account(label string) *accountHealth {
+	a, ok := h.accounts[label]
+	if !ok {
+		a = &accountHealth{}
+		h.accounts[label] = a
+	}
+	return a
+}
+
+// upstream records the outcome of a request to the Fitbit API, which
+// failed if it could not be made, was not authorized or was responded to
+// with a server error. Running out of quota is not a failure of the proxy,
+// nor is a caller hanging up.
+func (h *health) upstream(label string, resp *http.Response, err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	a := h.account(label)
+	switch {
+	case err != nil:
+		a.lastFailure, a.lastError = time.Now(), redact(err.Error())
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode >= 500:
+		a.lastFailure, a.lastError = time.Now(), resp.Status
+	default:
+		a.lastSuccess = time.Now()
+	}
+}
+
+// refreshed records the outcome of the latest refresh of an account's tokens.
+func (h *health) refreshed(label string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	a := h.account(label)
+	a.refreshError = ""
+	if err != nil {
+		a.refreshError = redact(err.Error())
+	}
+}
+
+// accountStatus is the health of an account, as reported by /healthz and
+// /readyz.
+type accountStatus struct {
+	Account             string     `json:"account"`
+	Loaded              bool       `json:"loaded"`
+	Expired             bool       `json:"expired"`
+	ExpiresInSeconds    float64    `json:"expires_in_seconds"`
+	Refreshable         bool       `json:"refreshable"`
+	LastRefreshError    string     `json:"last_refresh_error,omitempty"`
+	LastUpstreamSuccess *time.Time `json:"last_upstream_success,omitempty"`
+	LastUpstreamFailure *time.Time `json:"last_upstream_failure,omitempty"`
+	LastUpstreamError   string     `json:"last_upstream_error,omitempty"`
+	Ready               bool       `json:"ready"`
+}
+
+// status returns the health of each account of the proxy. An account is
+// ready if its tokens are loaded and either unexpired or refreshable. The
+// outcome of its latest request to the Fitbit API is reported but does not
+// make it unready, since requests are not made of an account that is not
+// in rotation, so it could not become ready again.
+func (s *server) status(now time.Time) []accountStatus {
+	labels := make(map[string]bool)
+	if s.manager == nil {
+		labels["default"] = true
+	} else {
+		users, _ := s.config()
+		for _, u := range users {
+			labels[accountLabel(u.FitbitUserID)] = true
+		}
+	}
+	// The tokens are read before the health is locked, since a client
+	// records the health of a refresh while its tokens are guarded.
+	tokens := make(map[string]bitfit.Tokens)
+	for _, a := range s.accounts() {
+		tokens[a.label] = a.client.CurrentTokens()
+		labels[a.label] = true
+	}
+	a := make([]accountStatus, 0, len(labels))
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+	for label := range labels {
+		st := accountStatus{Account: label}
+		h := s.health.account(label)
+		st.LastRefreshError, st.LastUpstreamError = h.refreshError, h.lastError
+		if !h.lastSuccess.IsZero() {
+			t := h.lastSuccess.UTC()
+			st.LastUpstreamSuccess = &t
+		}
+		if !h.lastFailure.IsZero() {
+			t := h.lastFailure.UTC()
+			st.LastUpstreamFailure = &t
+		}
+		if t, ok := tokens[label]; ok {
+			st.Loaded = t.Access != ""
+			st.ExpiresInSeconds = t.Expiration.Sub(now).Seconds()
+			st.Expired = !t.Expiration.After(now)
+			st.Refreshable = t.Refresh != "" && h.refreshError == ""
+		}
+		st.Ready = st.Loaded && (!st.Expired || st.Refreshable)
+		a = append(a, st)
+	}
+	sort.Slice(a, func(i, j int) bool { return a[i].Account < a[j].Account })
+	return a
+}
+
+// ready reports whether the proxy can serve any of its accounts, so that
+// one account whose tokens were revoked does not take the proxy out of
+// rotation for every other.
+func ready(accounts []accountStatus) bool {
+	for _, a := range accounts {
+		if a.Ready {
+			return true
+		}
+	}
+	return false
+}
+
+// handleHealth responds with whether the proxy is ready, as Service
+// Unavailable at /readyz if it is not. /healthz only reports whether the
+// proxy is serving, so is always OK. The health of each account (which
+// names its Fitbit user ID and errors) is only responded with to the user
+// of metrics or the admin, if authenticated as either.
+func (s *server) handleHealth(w http.ResponseWriter, r *http.Request, readiness bool) {
+	detailed, ok := s.authenticateHealth(w, r)
+	if !ok {
+		return
+	}
+	accounts := s.status(time.Now())
+	v := struct {
+		Ready    bool            `json:"ready"`
+		Accounts []accountStatus `json:"accounts,omitempty"`
+	}{Ready: ready(accounts)}
+	if detailed {
+		v.Accounts = accounts
+	}
+	b, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		writeResp(w, http.StatusInternalServerError, fmt.Sprintf("could not marshal health: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if readiness && !v.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(b)
+}
+
+// authenticateHealth reports whether a request for health may have the
+// health of each account, which it may if authenticated as the user of
+// metrics or the admin. A request without credentials may only have
+// whether the proxy is ready, and one with incorrect credentials is not
+// responded to with anything more.
+func (s *server) authenticateHealth(w http.ResponseWriter, r *http.Request) (detailed, ok bool) {
+	name, _, hasAuth := r.BasicAuth()
+	if !hasAuth {
+		return false, true
+	}
+	realm, u := "", (*User)(nil)
+	if s.authorizer != nil {
+		realm, u = "admin", &s.authorizer.Admin
+	}
+	if s.metricsUser != nil && (u == nil || name == s.metricsUser.Username) {
+		realm, u = "metrics", s.metricsUser
+	}
+	if u == nil {
+		return false, true
+	}
+	if !s.authenticateAs(w, r, u, realm) {
+		return false, false
+	}
+	return true, true
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aoeu/bitfit"
+)
+
+// keyPrefix begins every API key, so that keys are recognizable as such
+// (e.g. by secret scanners), and is followed by the key's ID and secret,
+// separated by an underscore.
+const keyPrefix = "bfk_"
+
+// APIKey is a key with which a caller authenticates as a user by bearer
+// token instead of by basic auth. Only a hash of the key's secret is kept.
+type APIKey struct {
+	ID       string
+	Hash     string
+	Username string
+	Label    string
+	// Scopes are the Fitbit scopes (e.g. "sleep") of the resources the key
+	// may request, or any resource if there are none.
+	Scopes []string
+	// Policy names a policy that restricts the requests of the key, besides
+	// any policy of its user.
+	Policy  string
+	Created time.Time
+	// Expires is when the key expires, or the zero time if it never does.
+	Expires time.Time
+	// Revoked is when the key was revoked, or the zero time if it was not.
+	Revoked time.Time
+}
+
+func hashKeySecret(secret string) string {
+	b := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(b[:])
+}
+
+// check returns why the key cannot be used at the time, if it cannot be.
+func (k APIKey) check(now time.Time) error {
+	switch {
+	case !k.Revoked.IsZero():
+		return fmt.Errorf("API key '%v' was revoked", k.ID)
+	case !k.Expires.IsZero() && !k.Expires.After(now):
+		return fmt.Errorf("API key '%v' expired", k.ID)
+	}
+	return nil
+}
+
+// allows reports whether the scopes of the key permit requesting the
+// resource at the URL. A scoped key may not request a resource of no
+// known scope.
+func (k APIKey) allows(rawURL string) bool {
+	if len(k.Scopes) == 0 {
+		return true
+	}
+	s := bitfit.RequiredScope(rawURL)
+	for _, ss := range k.Scopes {
+		if s != "" && s == ss {
+			return true
+		}
+	}
+	return false
+}
+
+// keyStore is a file of API keys that is read again whenever it changes,
+// so that keys created or revoked by the keys subcommand take effect in a
+// running server.
+type keyStore struct {
+	filepath string
+	mu       sync.Mutex
+	keys     map[string]APIKey
+	modTime  time.Time
+}
+
+func openKeyStore(filepath string) (*keyStore, error) {
+	s := &keyStore{filepath: filepath, keys: make(map[string]APIKey)}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload reads the file of keys if it changed since it was last read,
+// with the lock held. A file that does not exist yet has no keys.
+func (s *keyStore) reload() error {
+	fi, err := os.Stat(s.filepath)
+	switch {
+	case os.IsNotExist(err):
+		s.keys, s.modTime = make(map[string]APIKey), time.Time{}
+		return nil
+	case err != nil:
+		return fmt.Errorf("could not stat API keys file '%v': %v", s.filepath, err)
+	case fi.ModTime().Equal(s.modTime):
+		return nil
+	}
+	b, err := ioutil.ReadFile(s.filepath)
+	if err != nil {
+		return fmt.Errorf("API keys file '%v' could not be read: %v", s.filepath, err)
+	}
+	var a []APIKey
+	if err := json.Unmarshal(b, &a); err != nil {
+		return fmt.Errorf("could not unmarshal API keys at filepath '%v': %v", s.filepath, err)
+	}
+	s.keys = make(map[string]APIKey, len(a))
+	for _, k := range a {
+		s.keys[k.ID] = k
+	}
+	s.modTime = fi.ModTime()
+	return nil
+}
+
+// save writes the keys to a temporary file and renames it over the file of
+// keys, with the lock held.
+func (s *keyStore) save() error {
+	b, err := json.MarshalIndent(s.sorted(), "", "    ")
+	if err != nil {
+		return fmt.Errorf("could not serialize API keys: %v", err)
+	}
+	tmp := s.filepath + ".tmp"
+	// Hashes of keys are kept as secret as the tokens they stand in for.
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return fmt.Errorf("could not save API keys to file '%v': %v", tmp, err)
+	}
+	if err := os.Rename(tmp, s.filepath); err != nil {
+		return fmt.Errorf("could not save API keys to file '%v': %v", s.filepath, err)
+	}
+	return nil
+}
+
+func (s *keyStore) sorted() []APIKey {
+	a := make([]APIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		a = append(a, k)
+	}
+	sort.Slice(a, func(i, j int) bool { return a[i].Created.Before(a[j].Created) })
+	return a
+}
+
+// lookup returns the key of a bearer token if it is a valid key that can
+// be used at the time.
+func (s *keyStore) lookup(token string, now time.Time) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return APIKey{}, err
+	}
+	a := strings.SplitN(strings.TrimPrefix(token, keyPrefix), "_", 2)
+	if !strings.HasPrefix(token, keyPrefix) || len(a) != 2 {
+		return APIKey{}, errors.New("the bearer token is not an API key")
+	}
+	k, ok := s.keys[a[0]]
+	h := hashKeySecret(a[1])
+	if !ok || subtle.ConstantTimeCompare([]byte(h), []byte(k.Hash)) != 1 {
+		return APIKey{}, errors.New("incorrect API key")
+	}
+	if err := k.check(now); err != nil {
+		return APIKey{}, err
+	}
+	return k, nil
+}
+
+// create creates a key of the user that expires after ttl (or never if
+// ttl is 0), and returns the key and the token to authenticate with it,
+// which cannot be known again once returned.
+func (s *keyStore) create(username, label string, scopes []string, policy string, ttl time.Duration) (APIKey, string, error) {
+	id, secret := make([]byte, 6), make([]byte, 32)
+	for _, b := range [][]byte{id, secret} {
+		if _, err := rand.Read(b); err != nil {
+			return APIKey{}, "", fmt.Errorf("could not generate API key: %v", err)
+		}
+	}
+	now := time.Now()
+	k := APIKey{
+		ID:       hex.EncodeToString(id),
+		Username: username,
+		Label:    label,
+		Scopes:   scopes,
+		Policy:   policy,
+		Created:  now,
+	}
+	if ttl != 0 {
+		k.Expires = now.Add(ttl)
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+	k.Hash = hashKeySecret(token)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return APIKey{}, "", err
+	}
+	s.keys[k.ID] = k
+	if err := s.save(); err != nil {
+		delete(s.keys, k.ID)
+		return APIKey{}, "", err
+	}
+	return k, keyPrefix + k.ID + "_" + token, nil
+}
+
+func (s *keyStore) revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return err
+	}
+	k, ok := s.keys[id]
+	switch {
+	case !ok:
+		return fmt.Errorf("no API key '%v'", id)
+	case !k.Revoked.IsZero():
+		return nil
+	}
+	k.Revoked = time.Now()
+	s.keys[id] = k
+	if err := s.save(); err != nil {
+		k.Revoked = time.Time{}
+		s.keys[id] = k
+		return err
+	}
+	return nil
+}
+
+func (s *keyStore) list() ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s.sorted(), nil
+}
+
+var keysUsage = `usage: %v keys <subcommand> [flags]
+
+subcommands:
+	create	create an API key of -user, printing the key only once
+	list	list the API keys of -keysfile
+	revoke	revoke the API key of -id
+`
+
+// runKeys runs the keys subcommand, with which an admin manages API keys.
+func runKeys(args []string) error {
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, keysUsage, os.Args[0])
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet(os.Args[0]+" keys "+args[0], flag.ContinueOnError)
+	_ = fs.String(bitfit.ConfigFlagName, "", "config file (optional)")
+	keysFilepath := fs.String("keysfile", "", "a JSON file of the API keys of users")
+	username := fs.String("user", "", "the username of the user to create an API key of")
+	label := fs.String("label", "", "a label describing what an API key is used by")
+	scopes := fs.String("scopes", "", "comma-separated Fitbit scopes (e.g. sleep,heartrate) an API key is restricted to")
+	policy := fs.String("policy", "", "the name of a policy of the policies file of the server to restrict an API key to")
+	ttl := fs.Duration("expires", 0, "how long until an API key expires, or never if 0")
+	id := fs.String("id", "", "the ID of the API key to revoke")
+	if err := bitfit.ParseFlagSetArgs(fs, args[1:]); err != nil {
+		return err
+	}
+	if *keysFilepath == "" {
+		return errors.New("an API keys filepath is required")
+	}
+	s, err := openKeyStore(*keysFilepath)
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "create":
+		if *username == "" {
+			return errors.New("a username is required to create an API key")
+		}
+		var a []string
+		if *scopes != "" {
+			a = strings.Split(*scopes, ",")
+		}
+		k, token, err := s.create(*username, *label, a, *policy, *ttl)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("created API key '%v' of user '%v'; it will not be shown again:\n%v\n", k.ID, k.Username, token)
+	case "list":
+		a, err := s.list()
+		if err != nil {
+			return err
+		}
+		for _, k := range a {
+			state := "active"
+			if err := k.check(time.Now()); err != nil {
+				state = err.Error()
+			}
+			fmt.Printf("%v\t%v\t%q\t%v\t%v\t%v\n", k.ID, k.Username, k.Label, strings.Join(k.Scopes, ","), k.Policy, state)
+		}
+	case "revoke":
+		if *id == "" {
+			return errors.New("the ID of the API key to revoke is required")
+		}
+		if err := s.revoke(*id); err != nil {
+			return err
+		}
+		fmt.Printf("revoked API key '%v'\n", *id)
+	default:
+		fmt.Fprintf(os.Stderr, keysUsage, os.Args[0])
+		os.Exit(2)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/fcgi"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/aoeu/bitfit"
+)
+
+// config returns the users and policies of the server, as of their latest
+// reload.
+func (s *server) config() (map[string]User, map[string]Policy) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.users, s.policies
+}
+
+// reload reloads the users and policies of the args, which replace those
+// of the server only if all of them load.
+func (s *server) reload(a Args) error {
+	users, err := loadUsersFromArgs(a)
+	if err != nil {
+		return err
+	}
+	policies, err := loadPoliciesFromArgs(a, users)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users, s.policies = users, policies
+	return nil
+}
+
+// reloadArgs parses the flags and args file again, as on SIGHUP. Whether
+// there is a users file cannot change without a restart, since the Fitbit
+// tokens of a single tokens file or a directory of them are loaded once.
+func reloadArgs(prev Args) (Args, error) {
+	fs, a := setupFlagsAndArgs("args.json")
+	if err := bitfit.ParseFlagSet(fs); err != nil {
+		return a, err
+	}
+	if err := a.Validate(); err != nil {
+		return a, err
+	}
+	if (*a.usersFilepath == "") != (*prev.usersFilepath == "") {
+		s := "a users file cannot be added or removed without a restart"
+		return a, fmt.Errorf(s)
+	}
+	return a, nil
+}
+
+// certReloader serves the TLS certificate of the cert and key files as of
+// their latest load, so that a renewed certificate can be loaded without
+// dropping connections.
+type certReloader struct {
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func (c *certReloader) load(certFilepath, keyFilepath string) error {
+	cert, err := tls.LoadX509KeyPair(certFilepath, keyFilepath)
+	if err != nil {
+		s := "could not load TLS certificate '%v' and key '%v': %v"
+		return fmt.Errorf(s, certFilepath, keyFilepath, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cert = &cert
+	return nil
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// flushTokens saves the tokens of each account, so that none refreshed are
+// lost on exit.
+func (s *server) flushTokens() error {
+	var errs []string
+	for _, a := range s.accounts() {
+		if err := a.client.SaveTokens(); err != nil {
+			errs = append(errs, fmt.Sprintf("account '%v': %v", a.label, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("could not save tokens of %v", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// serve serves until SIGTERM (or an interrupt), on which requests in flight
+// are drained for up to the shutdown timeout before the tokens are saved and
+// the logs closed. SIGHUP reloads the users, policies and TLS certificate.
+// A FastCGI server cannot be drained, so only saves the tokens on exit.
+func (s *server) serve(a Args) error {
+	hs := &http.Server{Addr: *a.port, Handler: s}
+	var certs *certReloader
+	if !*a.useFCGI && !*a.useHTTP {
+		certs = &certReloader{}
+		if err := certs.load(*a.certFilepath, *a.keyFilepath); err != nil {
+			return err
+		}
+		hs.TLSConfig = &tls.Config{GetCertificate: certs.getCertificate}
+	}
+	errs := make(chan error, 1)
+	go func() {
+		switch {
+		case *a.useFCGI:
+			errs <- fcgi.Serve(nil, s)
+		case *a.useHTTP:
+			errs <- hs.ListenAndServe()
+		default:
+			errs <- hs.ListenAndServeTLS("", "")
+		}
+	}()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(sigs)
+	for {
+		select {
+		case err := <-errs:
+			if err == http.ErrServerClosed {
+				err = nil
+			}
+			return err
+		case sig := <-sigs:
+			if sig == syscall.SIGHUP {
+				if err := s.reloadFromArgs(a, certs); err != nil {
+					log.Printf("could not reload: %v", err)
+				} else {
+					log.Println("reloaded users, policies and certificate")
+				}
+				continue
+			}
+			log.Printf("shutting down on %v", sig)
+			ctx, cancel := context.WithTimeout(context.Background(), *a.shutdownTimeout)
+			err := hs.Shutdown(ctx)
+			cancel()
+			if err != nil {
+				log.Printf("could not drain requests in flight: %v", err)
+			}
+			if err := s.flushTokens(); err != nil {
+				log.Println(err)
+			}
+			s.accessLog.close()
+			s.auditLog.close()
+			return nil
+		}
+	}
+}
+
+// reloadFromArgs reloads the users and policies, and the TLS certificate if
+// served over HTTPS, of the flags and args file as they are now.
+func (s *server) reloadFromArgs(prev Args, certs *certReloader) error {
+	a, err := reloadArgs(prev)
+	if err != nil {
+		return err
+	}
+	if err := s.reload(a); err != nil {
+		return err
+	}
+	if certs == nil {
+		return nil
+	}
+	return certs.load(*a.certFilepath, *a.keyFilepath)
+}
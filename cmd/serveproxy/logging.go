@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aoeu/bitfit/cache"
+)
+
+// jsonLog writes entries as lines of JSON.
+type jsonLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *jsonLog) write(v interface{}) {
+	if l == nil {
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("could not marshal log entry: %v", err)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.w == nil {
+		return
+	}
+	if _, err := l.w.Write(append(b, '\n')); err != nil {
+		log.Printf("could not write log entry: %v", err)
+	}
+}
+
+// close closes the file of the log, unless it is stdout.
+func (l *jsonLog) close() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if f, ok := l.w.(*os.File); ok && f != os.Stdout {
+		if err := f.Close(); err != nil {
+			log.Printf("could not close log file '%v': %v", f.Name(), err)
+		}
+	}
+	l.w = nil
+}
+
+// openLog opens a file to append a log to, or stdout if the filepath is
+// "-", or no file if it is empty.
+func openLog(filepath string) (*jsonLog, error) {
+	switch filepath {
+	case "":
+		return nil, nil
+	case "-":
+		return &jsonLog{w: os.Stdout}, nil
+	}
+	f, err := os.OpenFile(filepath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open log file '%v': %v", filepath, err)
+	}
+	return &jsonLog{w: f}, nil
+}
+
+// secretPatterns match secrets that may appear in error messages (such as
+// the body of a failed response of the Fitbit API) so they can be redacted.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b(Bearer|Basic)\s+[A-Za-z0-9._~+/=-]+`),
+	regexp.MustCompile(`(?i)"(access_token|refresh_token|id_token|client_secret|code)"\s*:\s*"[^"]*"`),
+	regexp.MustCompile(`(?i)\b(access_token|refresh_token|client_secret|code|token|password)=[^&\s]+`),
+	regexp.MustCompile(keyPrefix + `[0-9a-f]+_[A-Za-z0-9_-]+`),
+}
+
+// redact replaces any secrets in s, keeping the name of what was redacted.
+func redact(s string) string {
+	for _, re := range secretPatterns {
+		s = re.ReplaceAllStringFunc(s, func(m string) string {
+			switch {
+			case strings.HasPrefix(m, keyPrefix):
+				return keyPrefix + "[REDACTED]"
+			case strings.HasPrefix(m, `"`):
+				return m[:strings.Index(m, ":")+1] + `"[REDACTED]"`
+			case strings.Contains(m, "="):
+				return m[:strings.Index(m, "=")+1] + "[REDACTED]"
+			}
+			return strings.Fields(m)[0] + " [REDACTED]"
+		})
+	}
+	return s
+}
+
+// redactQuery returns the query of a URL with the values of parameters that
+// may be secret redacted.
+func redactQuery(u *url.URL) string {
+	if u.RawQuery == "" {
+		return ""
+	}
+	q := u.Query()
+	for k := range q {
+		switch strings.ToLower(k) {
+		case "code", "token", "access_token", "refresh_token", "client_secret", "state", "password", "key":
+			q.Set(k, "[REDACTED]")
+		}
+	}
+	return q.Encode()
+}
+
+// requestInfo is what is learned of a request while it is handled, to be
+// logged once it is responded to.
+type requestInfo struct {
+	mu       sync.Mutex
+	caller   string
+	keyID    string
+	upstream time.Duration
+}
+
+type requestInfoContextKey struct{}
+
+func infoOf(r *http.Request) *requestInfo {
+	if i, ok := r.Context().Value(requestInfoContextKey{}).(*requestInfo); ok {
+		return i
+	}
+	return &requestInfo{}
+}
+
+func withRequestInfo(r *http.Request) (*http.Request, *requestInfo) {
+	i := &requestInfo{}
+	return r.WithContext(context.WithValue(r.Context(), requestInfoContextKey{}, i)), i
+}
+
+func (i *requestInfo) setCaller(c caller) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.caller = c.User.Username
+	if c.Key != nil {
+		i.keyID = c.Key.ID
+	}
+}
+
+func (i *requestInfo) addUpstream(d time.Duration) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.upstream += d
+}
+
+// accessEntry is an entry of the access log, of a request to the proxy.
+type accessEntry struct {
+	Time       time.Time `json:"time"`
+	Caller     string    `json:"caller,omitempty"`
+	KeyID      string    `json:"key_id,omitempty"`
+	Remote     string    `json:"remote"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Query      string    `json:"query,omitempty"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	LatencyMS  float64   `json:"latency_ms"`
+	UpstreamMS float64   `json:"upstream_ms"`
+	Cache      string    `json:"cache,omitempty"`
+}
+
+func (s *server) logAccess(r *http.Request, w *statusWriter, i *requestInfo, start time.Time) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	s.accessLog.write(accessEntry{
+		Time:       start.UTC(),
+		Caller:     i.caller,
+		KeyID:      i.keyID,
+		Remote:     remoteHost(r),
+		Method:     r.Method,
+		Path:       redact(r.URL.Path),
+		Query:      redactQuery(r.URL),
+		Status:     w.status,
+		Bytes:      w.bytes,
+		LatencyMS:  float64(time.Since(start).Microseconds()) / 1000,
+		UpstreamMS: float64(i.upstream.Microseconds()) / 1000,
+		Cache:      w.Header().Get(cache.HitHeader),
+	})
+}
+
+// auditEntry is an entry of the audit log, of an event of security.
+type auditEntry struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`
+	Caller  string    `json:"caller,omitempty"`
+	KeyID   string    `json:"key_id,omitempty"`
+	Account string    `json:"account,omitempty"`
+	Remote  string    `json:"remote,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// auditAuthFailure audits a failed attempt to authenticate as a username
+// or with an API key (of which only the ID is logged).
+func (s *server) auditAuthFailure(r *http.Request, username, token, reason string) {
+	e := auditEntry{
+		Time:   time.Now().UTC(),
+		Event:  "auth_failure",
+		Caller: username,
+		Remote: remoteHost(r),
+		Error:  redact(reason),
+	}
+	if a := strings.SplitN(strings.TrimPrefix(token, keyPrefix), "_", 2); strings.HasPrefix(token, keyPrefix) && len(a) == 2 {
+		e.KeyID = a[0]
+	}
+	s.auditLog.write(e)
+}
+
+// tokensRefreshed counts and audits an attempt to refresh the tokens of
+// an account, as the Refreshed func of the account's client.
+func (s *server) tokensRefreshed(account string, err error) {
+	s.metrics.refreshed(account, err)
+	s.health.refreshed(account, err)
+	e := auditEntry{Time: time.Now().UTC(), Event: "token_refresh", Account: account}
+	if err != nil {
+		e.Event, e.Error = "token_refresh_failure", redact(err.Error())
+	}
+	s.auditLog.write(e)
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aoeu/bitfit"
+)
+
+// latencyBuckets are the upper bounds in seconds of the buckets of the
+// histogram of latencies of upstream requests.
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// maxRoutes bounds the number of distinct paths metrics are kept of, past
+// which requests are counted as of the path "other", so that requests of
+// arbitrary paths cannot grow the metrics without bound.
+const maxRoutes = 500
+
+// metrics are kept of the requests to the proxy and the refreshes of
+// tokens, and exposed in the Prometheus text format.
+type metrics struct {
+	mu              sync.Mutex
+	requests        map[[2]string]uint64
+	latencies       map[string]*histogram
+	refreshes       map[string]uint64
+	refreshFailures map[string]uint64
+	routes          map[string]bool
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:        make(map[[2]string]uint64),
+		latencies:       make(map[string]*histogram),
+		refreshes:       make(map[string]uint64),
+		refreshFailures: make(map[string]uint64),
+		routes:          make(map[string]bool),
+	}
+}
+
+// route returns the path with its dates, IDs and user IDs replaced by
+// placeholders, e.g. "/1.2/user/{user}/sleep/date/{date}.json".
+func route(path string) string {
+	if !strings.HasPrefix(path, "/1/") && !strings.HasPrefix(path, "/1.1/") && !strings.HasPrefix(path, "/1.2/") {
+		return "other"
+	}
+	a := strings.Split(path, "/")
+	for i, s := range a {
+		ext := ""
+		if strings.HasSuffix(s, ".json") {
+			s, ext = strings.TrimSuffix(s, ".json"), ".json"
+		}
+		switch _, err := time.Parse("2006-01-02", s); {
+		case i > 0 && a[i-1] == "user" && s != "-":
+			a[i] = "{user}" + ext
+		case err == nil:
+			a[i] = "{date}" + ext
+		case i > 1 && s != "" && strings.Trim(s, "0123456789") == "":
+			a[i] = "{id}" + ext
+		}
+	}
+	return strings.Join(a, "/")
+}
+
+// routeOf returns the route of the path, or "other" if too many routes
+// have been seen, with the lock held.
+func (m *metrics) routeOf(path string) string {
+	r := route(path)
+	if !m.routes[r] && len(m.routes) >= maxRoutes {
+		return "other"
+	}
+	m.routes[r] = true
+	return r
+}
+
+func (m *metrics) countRequest(path string, status int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[[2]string{m.routeOf(path), strconv.Itoa(status)}]++
+}
+
+func (m *metrics) observeLatency(path string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := m.routeOf(path)
+	h, ok := m.latencies[r]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latencies[r] = h
+	}
+	for i, b := range latencyBuckets {
+		if d.Seconds() <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += d.Seconds()
+	h.count++
+}
+
+// refreshed counts a refresh of the tokens of an account, as may be set
+// as the Refreshed func of the account's client.
+func (m *metrics) refreshed(account string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.refreshes[account]++
+	if err != nil {
+		m.refreshFailures[account]++
+	}
+}
+
+// upstreamTransport observes the latency and outcome of each request it
+// makes to the Fitbit API with Next.
+type upstreamTransport struct {
+	Next   http.RoundTripper
+	server *server
+}
+
+func (t upstreamTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.Next.RoundTrip(r)
+	d := time.Since(start)
+	t.server.metrics.observeLatency(r.URL.Path, d)
+	infoOf(r).addUpstream(d)
+	id, _ := r.Context().Value(accountContextKey{}).(string)
+	t.server.health.upstream(accountLabel(id), resp, err)
+	return resp, err
+}
+
+// account is a Fitbit account of the proxy, by the label it is exposed by
+// in metrics.
+type account struct {
+	label  string
+	client *bitfit.Client
+}
+
+// accounts returns the Fitbit accounts of the proxy with initialized
+// clients, of which the client of the single tokens file is labelled
+// "default".
+func (s *server) accounts() []account {
+	a := make([]account, 0)
+	if s.client != nil {
+		a = append(a, account{"default", s.client})
+	}
+	if s.manager == nil {
+		return a
+	}
+	for _, id := range s.manager.UserIDs() {
+		if c, err := s.manager.Client(id); err == nil {
+			a = append(a, account{id, c})
+		}
+	}
+	return a
+}
+
+// accountLabel returns the label of the account of a Fitbit user ID.
+func accountLabel(fitbitUserID string) string {
+	if fitbitUserID == "" {
+		return "default"
+	}
+	return fitbitUserID
+}
+
+// writeMetrics writes the metrics of the proxy in the Prometheus text format.
+func (s *server) writeMetrics(w io.Writer, now time.Time) {
+	m := s.metrics
+	m.mu.Lock()
+	writeHeader(w, "bitfit_proxy_requests_total", "counter", "Requests to the proxy by path and status.")
+	keys := make([][2]string, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1]
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "bitfit_proxy_requests_total{path=%v,status=%v} %d\n", strconv.Quote(k[0]), strconv.Quote(k[1]), m.requests[k])
+	}
+
+	writeHeader(w, "bitfit_upstream_latency_seconds", "histogram", "Latency of requests to the Fitbit API by path.")
+	for _, r := range sortedKeys(m.latencies) {
+		h, p := m.latencies[r], strconv.Quote(r)
+		for i, b := range latencyBuckets {
+			fmt.Fprintf(w, "bitfit_upstream_latency_seconds_bucket{path=%v,le=\"%v\"} %d\n", p, b, h.counts[i])
+		}
+		fmt.Fprintf(w, "bitfit_upstream_latency_seconds_bucket{path=%v,le=\"+Inf\"} %d\n", p, h.count)
+		fmt.Fprintf(w, "bitfit_upstream_latency_seconds_sum{path=%v} %v\n", p, h.sum)
+		fmt.Fprintf(w, "bitfit_upstream_latency_seconds_count{path=%v} %d\n", p, h.count)
+	}
+
+	writeHeader(w, "bitfit_token_refreshes_total", "counter", "Attempts to refresh the tokens of each account.")
+	for _, a := range sortedKeys(m.refreshes) {
+		fmt.Fprintf(w, "bitfit_token_refreshes_total{account=%v} %d\n", strconv.Quote(a), m.refreshes[a])
+	}
+	writeHeader(w, "bitfit_token_refresh_failures_total", "counter", "Failed attempts to refresh the tokens of each account.")
+	for _, a := range sortedKeys(m.refreshFailures) {
+		fmt.Fprintf(w, "bitfit_token_refresh_failures_total{account=%v} %d\n", strconv.Quote(a), m.refreshFailures[a])
+	}
+	m.mu.Unlock()
+
+	accounts := s.accounts()
+	writeHeader(w, "bitfit_token_expiry_seconds", "gauge", "Seconds until the access token of each account expires, negative once expired.")
+	for _, a := range accounts {
+		d := a.client.CurrentTokens().Expiration.Sub(now)
+		fmt.Fprintf(w, "bitfit_token_expiry_seconds{account=%v} %v\n", strconv.Quote(a.label), d.Seconds())
+	}
+	writeHeader(w, "bitfit_rate_limit_remaining", "gauge", "Requests remaining of the hourly quota of each account, as last reported by the Fitbit API.")
+	for _, a := range accounts {
+		if rl := a.client.RateLimit(); rl.Known() {
+			fmt.Fprintf(w, "bitfit_rate_limit_remaining{account=%v} %d\n", strconv.Quote(a.label), rl.Remaining)
+		}
+	}
+	writeHeader(w, "bitfit_rate_limit_reset_seconds", "gauge", "Seconds until the hourly quota of each account resets.")
+	for _, a := range accounts {
+		if rl := a.client.RateLimit(); rl.Known() {
+			fmt.Fprintf(w, "bitfit_rate_limit_reset_seconds{account=%v} %v\n", strconv.Quote(a.label), rl.Reset.Sub(now).Seconds())
+		}
+	}
+
+	if s.cache == nil {
+		return
+	}
+	st := s.cache.Stats()
+	writeHeader(w, "bitfit_cache_hits_total", "counter", "Requests responded to from the cache.")
+	fmt.Fprintf(w, "bitfit_cache_hits_total %d\n", st.Hits)
+	writeHeader(w, "bitfit_cache_misses_total", "counter", "Cacheable requests that were not in the cache.")
+	fmt.Fprintf(w, "bitfit_cache_misses_total %d\n", st.Misses)
+	writeHeader(w, "bitfit_cache_errors_total", "counter", "Errors of the backend of the cache.")
+	fmt.Fprintf(w, "bitfit_cache_errors_total %d\n", st.Errors)
+}
+
+func writeHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, typ)
+}
+
+func sortedKeys(m interface{}) []string {
+	a := make([]string, 0)
+	switch m := m.(type) {
+	case map[string]uint64:
+		for k := range m {
+			a = append(a, k)
+		}
+	case map[string]*histogram:
+		for k := range m {
+			a = append(a, k)
+		}
+	}
+	sort.Strings(a)
+	return a
+}
+
+// handleMetrics responds with the metrics of the proxy to the user of the
+// metrics, who is authenticated separately from the users of the proxy.
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if !s.authenticateAs(w, r, s.metricsUser, "metrics") {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.writeMetrics(w, time.Now())
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyHash is compared against the password of an unknown user, so that
+// whether a username exists takes no less time to learn than its password.
+const dummyHash = "$2a$10$.50K.TcWIxhTfwA60E993OlTAo6Abwlj6C7hdNuyY2AXp80phkL3S"
+
+// loadHtpasswd loads an htpasswd-style file of lines of a username and the
+// hash of their password separated by a colon, such as is written by
+// `htpasswd -B` (bcrypt) or with the encoded output of the argon2 command.
+// Blank lines and lines beginning with # are ignored.
+func loadHtpasswd(filepath string) (map[string]string, error) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, fmt.Errorf("htpasswd file '%v' could not be opened: %v", filepath, err)
+	}
+	defer f.Close()
+	m := make(map[string]string)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		a := strings.SplitN(line, ":", 2)
+		if len(a) != 2 || a[0] == "" || a[1] == "" {
+			return nil, fmt.Errorf("line %d of htpasswd file '%v' is not a username and hash", n, filepath)
+		}
+		if !isSupportedHash(a[1]) {
+			s := "the hash of user '%v' of htpasswd file '%v' is neither bcrypt nor argon2"
+			return nil, fmt.Errorf(s, a[0], filepath)
+		}
+		m[a[0]] = a[1]
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("could not read htpasswd file '%v': %v", filepath, err)
+	}
+	return m, nil
+}
+
+func isSupportedHash(hash string) bool {
+	for _, p := range []string{"$2a$", "$2b$", "$2y$", "$argon2id$", "$argon2i$"} {
+		if strings.HasPrefix(hash, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPassword reports whether the password matches a bcrypt or argon2
+// hash, in time that does not depend on how much of the password matches.
+func checkPassword(hash, password string) bool {
+	if strings.HasPrefix(hash, "$argon2") {
+		return checkArgon2(hash, password)
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// checkArgon2 checks a password against an argon2 hash encoded in the PHC
+// string format, e.g. "$argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>".
+func checkArgon2(hash, password string) bool {
+	a := strings.Split(hash, "$")
+	if len(a) != 6 {
+		return false
+	}
+	var version int
+	if _, err := fmt.Sscanf(a[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(a[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(a[4])
+	if err != nil {
+		return false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(a[5])
+	if err != nil || len(key) == 0 {
+		return false
+	}
+	var k []byte
+	switch a[1] {
+	case "argon2id":
+		k = argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	case "argon2i":
+		k = argon2.Key([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	default:
+		return false
+	}
+	return subtle.ConstantTimeCompare(k, key) == 1
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// Rule allows requests of any of its methods to any of its paths, or of
+// any method or to any path if it has none. A path ending in a slash
+// allows every path beneath it, and others are patterns as matched by
+// path.Match, e.g. "/1/user/-/activities/heart/date/*/1d.json".
+type Rule struct {
+	Methods []string
+	Paths   []string
+}
+
+func (r Rule) allows(method, p string) bool {
+	return r.allowsMethod(method) && r.allowsPath(p)
+}
+
+func (r Rule) allowsMethod(method string) bool {
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, m := range r.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) allowsPath(p string) bool {
+	if len(r.Paths) == 0 {
+		return true
+	}
+	for _, pattern := range r.Paths {
+		if strings.HasSuffix(pattern, "/") && strings.HasPrefix(p, pattern) {
+			return true
+		}
+		if ok, err := path.Match(pattern, p); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy allows the requests that any of its rules allow, e.g. a policy of
+// read-only sleep and heart rate data:
+//
+//	[{"methods": ["GET"], "paths": ["/1.2/user/-/sleep/", "/1/user/-/activities/heart/"]}]
+type Policy []Rule
+
+// allows reports whether the policy allows a request of the method to the
+// path, which must be clean so that it cannot escape a path it is allowed
+// to request beneath (e.g. "/1.2/user/-/sleep/../../../1/user/-/foods").
+func (p Policy) allows(method, urlPath string) bool {
+	if c := path.Clean(urlPath); c != urlPath && c+"/" != urlPath {
+		return false
+	}
+	for _, r := range p {
+		if r.allows(method, urlPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadPolicies loads a JSON file of an object of policies by name.
+func loadPolicies(filepath string) (map[string]Policy, error) {
+	b, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return nil, fmt.Errorf("filepath of policies '%v' could not be read: %v", filepath, err)
+	}
+	m := make(map[string]Policy)
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("could not unmarshal policies at filepath '%v': %v", filepath, err)
+	}
+	for name, p := range m {
+		for _, r := range p {
+			for _, pattern := range r.Paths {
+				if _, err := path.Match(pattern, ""); err != nil {
+					s := "path '%v' of policy '%v' of '%v' is not a valid pattern: %v"
+					return nil, fmt.Errorf(s, pattern, name, filepath, err)
+				}
+			}
+		}
+	}
+	return m, nil
+}
+
+// loadPoliciesFromArgs loads the policies of the policies file, if any, and
+// checks that those the users name exist.
+func loadPoliciesFromArgs(a Args, users map[string]User) (map[string]Policy, error) {
+	policies := make(map[string]Policy)
+	if *a.policiesFilepath != "" {
+		var err error
+		if policies, err = loadPolicies(*a.policiesFilepath); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkPolicies(users, policies); err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+// policyOf returns the name of the policy of a user, which is the policy
+// the user names or else any policy named for the user's username.
+func policyOf(u User, policies map[string]Policy) (string, bool) {
+	if u.Policy != "" {
+		return u.Policy, true
+	}
+	_, ok := policies[u.Username]
+	return u.Username, ok
+}
+
+// checkPolicies returns an error for the first user that names a policy
+// that does not exist.
+func checkPolicies(users map[string]User, policies map[string]Policy) error {
+	for _, u := range users {
+		if _, ok := policies[u.Policy]; u.Policy != "" && !ok {
+			return fmt.Errorf("policy '%v' of user '%v' does not exist", u.Policy, u.Username)
+		}
+	}
+	return nil
+}
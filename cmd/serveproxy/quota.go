@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aoeu/bitfit"
+)
+
+// PriorityHeader is the header with which a caller marks a request as of
+// "low" priority, which the governor holds back to reserve the quota of
+// requests for others.
+const PriorityHeader = "X-Bitfit-Priority"
+
+// lowPriorityContextKey is that of whether a request is of low priority.
+type lowPriorityContextKey struct{}
+
+// governor budgets each Fitbit account's hourly quota of requests, as
+// reported by the rate limit headers of the account's latest response.
+// Once fewer requests than Reserve remain, low priority requests wait
+// until the quota resets if it resets within MaxWait, and are otherwise
+// responded to as Too Many Requests.
+type governor struct {
+	Next    http.RoundTripper
+	Reserve int
+	MaxWait time.Duration
+
+	mu       sync.Mutex
+	inFlight map[*bitfit.Client]int
+	now      func() time.Time
+}
+
+func newGovernor(next http.RoundTripper) *governor {
+	return &governor{
+		Next:     next,
+		MaxWait:  30 * time.Second,
+		inFlight: make(map[*bitfit.Client]int),
+		now:      time.Now,
+	}
+}
+
+func (g *governor) RoundTrip(r *http.Request) (*http.Response, error) {
+	low, _ := r.Context().Value(lowPriorityContextKey{}).(bool)
+	c, ok := r.Context().Value(clientContextKey{}).(*bitfit.Client)
+	if !ok || g.Reserve <= 0 {
+		return g.Next.RoundTrip(r)
+	}
+	if low {
+		if wait, ok := g.budget(c); !ok {
+			if wait > g.MaxWait {
+				return tooManyRequests(r, wait), nil
+			}
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-r.Context().Done():
+				t.Stop()
+				return nil, r.Context().Err()
+			}
+		}
+	}
+	g.mu.Lock()
+	g.inFlight[c]++
+	g.mu.Unlock()
+	defer func() {
+		g.mu.Lock()
+		if g.inFlight[c]--; g.inFlight[c] == 0 {
+			delete(g.inFlight, c)
+		}
+		g.mu.Unlock()
+	}()
+	return g.Next.RoundTrip(r)
+}
+
+// budget reports whether more than the reserve of the client's quota
+// remains, not counting requests in flight, and otherwise how long until
+// the quota resets.
+func (g *governor) budget(c *bitfit.Client) (time.Duration, bool) {
+	rl := c.RateLimit()
+	now := g.now()
+	if !rl.Known() || !rl.Reset.After(now) {
+		return 0, true
+	}
+	g.mu.Lock()
+	remaining := rl.Remaining - g.inFlight[c]
+	g.mu.Unlock()
+	if remaining > g.Reserve {
+		return 0, true
+	}
+	return rl.Reset.Sub(now), false
+}
+
+// tooManyRequests is the response to a low priority request that is held
+// back until the quota resets.
+func tooManyRequests(r *http.Request, retryAfter time.Duration) *http.Response {
+	s := "the quota of requests is reserved for those not of low priority until it resets in %v"
+	b := []byte(fmt.Sprintf(s, retryAfter.Round(time.Second)))
+	h := make(http.Header)
+	h.Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds()+1)))
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	return &http.Response{
+		Status:        "429 Too Many Requests",
+		StatusCode:    http.StatusTooManyRequests,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          ioutil.NopCloser(bytes.NewReader(b)),
+		ContentLength: int64(len(b)),
+		Request:       r,
+	}
+}
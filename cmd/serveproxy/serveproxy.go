@@ -1,33 +1,53 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"net/http/fcgi"
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aoeu/bitfit"
-)
-
-var (
-	apiProxy *httputil.ReverseProxy
-	username string
-	password string
+	"github.com/aoeu/bitfit/cache"
 )
 
 type Args struct {
 	bitfit.Args
-	username     *string
-	password     *string
-	certFilepath *string
-	keyFilepath  *string
-	port         *string
-	useFCGI      *bool
-	useHTTP      *bool
+	username         *string
+	password         *string
+	usersFilepath    *string
+	htpasswdFilepath *string
+	keysFilepath     *string
+	policiesFilepath *string
+	tokensDirpath    *string
+	maxFailures      *int
+	lockout          *time.Duration
+	certFilepath     *string
+	keyFilepath      *string
+	port             *string
+	useFCGI          *bool
+	useHTTP          *bool
+	useCache         *bool
+	cacheDirpath     *string
+	reserveQuota     *int
+	quotaWait        *time.Duration
+	metricsUsername  *string
+	metricsPassword  *string
+	accessLogpath    *string
+	auditLogpath     *string
+	shutdownTimeout  *time.Duration
+	adminUsername    *string
+	adminPassword    *string
+	redirectURL      *string
+	authScopes       *string
 }
 
 func setupFlagsAndArgs(configFilepath string) (*flag.FlagSet, Args) {
@@ -35,26 +55,77 @@ func setupFlagsAndArgs(configFilepath string) (*flag.FlagSet, Args) {
 	s := "is required on client requests for HTTP basic auth, as per RFC 7617"
 	ss := "to use for the proxy server for TLS / HTTPS"
 	args := Args{
-		Args:         bitfit.ArgsWithFlagSet(fs, configFilepath),
-		username:     fs.String("username", "", "a username "+s),
-		password:     fs.String("password", "", "a password "+s),
-		certFilepath: fs.String("certfile", "cert.txt", "a cert "+ss),
-		keyFilepath:  fs.String("keyfile", "key.txt", "a key "+ss),
-		port:         fs.String("port", ":9090", "the port to serve on"),
-		useFCGI:      fs.Bool("cgi", false, "serve HTTP via FastCGI"),
-		useHTTP:      fs.Bool("http", true, "serve via HTTP instead of HTTPS"),
+		Args:             bitfit.ArgsWithFlagSet(fs, configFilepath),
+		username:         fs.String("username", "", "a username "+s),
+		password:         fs.String("password", "", "a password "+s),
+		usersFilepath:    fs.String("usersfile", "", "a JSON file of users, each with their own Fitbit tokens, instead of a single username and password"),
+		htpasswdFilepath: fs.String("htpasswd", "", "an htpasswd file of bcrypt or argon2 hashes of the passwords of users, instead of passwords in plain text"),
+		policiesFilepath: fs.String("policiesfile", "", "a JSON file of named policies of the methods and paths users or API keys may request"),
+		keysFilepath:     fs.String("keysfile", "", "a JSON file of API keys with which users may authenticate as bearer tokens, as managed by the keys subcommand"),
+		tokensDirpath:    fs.String("tokensdir", "", "a directory of JSON files of each Fitbit user's tokens, named by Fitbit user ID, for the users of -usersfile"),
+		maxFailures:      fs.Int("maxfailures", 5, "the number of failed attempts to authenticate after which a user or remote address is locked out"),
+		lockout:          fs.Duration("lockout", 15*time.Minute, "how long a user or remote address is locked out for after too many failed attempts to authenticate"),
+		certFilepath:     fs.String("certfile", "cert.txt", "a cert "+ss),
+		keyFilepath:      fs.String("keyfile", "key.txt", "a key "+ss),
+		port:             fs.String("port", ":9090", "the port to serve on"),
+		useFCGI:          fs.Bool("cgi", false, "serve HTTP via FastCGI"),
+		useHTTP:          fs.Bool("http", true, "serve via HTTP instead of HTTPS"),
+		useCache:         fs.Bool("cache", false, "cache responses to GET requests in memory"),
+		cacheDirpath:     fs.String("cachedir", "", "a directory to cache responses to GET requests in, instead of in memory"),
+		reserveQuota:     fs.Int("reservequota", 0, "the number of requests of each hourly quota to reserve for requests not of low priority (as marked by the "+PriorityHeader+" header)"),
+		metricsUsername:  fs.String("metricsusername", "", "a username required to scrape metrics at /metrics, which is not served without one"),
+		metricsPassword:  fs.String("metricspassword", "", "a password required to scrape metrics at /metrics"),
+		accessLogpath:    fs.String("accesslog", "-", "a file to append the JSON access log to, or - for stdout, or nothing to not log"),
+		auditLogpath:     fs.String("auditlog", "-", "a file to append the JSON audit log of failures to authenticate and refreshes of tokens to, or - for stdout, or nothing to not log"),
+		quotaWait:        fs.Duration("quotawait", 30*time.Second, "the longest a low priority request waits for the quota to reset before it is rejected"),
+		adminUsername:    fs.String("adminusername", "", "a username required to authorize the Fitbit API again at /authorize, which is not served without one"),
+		adminPassword:    fs.String("adminpassword", "", "a password required to authorize the Fitbit API again at /authorize"),
+		redirectURL:      fs.String("redirecturl", "", "the URL of /callback as registered as the redirect URL of the Fitbit application, if not that of the host /authorize is requested at"),
+		authScopes:       fs.String("authscopes", defaultScopes, "the scopes to authorize the Fitbit API with at /authorize"),
+		shutdownTimeout:  fs.Duration("shutdowntimeout", 30*time.Second, "the longest requests in flight are waited for to complete on SIGTERM before the proxy exits"),
 	}
 	return fs, args
 }
 
 func (a Args) Validate() error {
+	if *a.metricsUsername != "" && *a.metricsPassword == "" {
+		return fmt.Errorf("a password of the metrics username is required")
+	}
+	if *a.adminUsername != "" && *a.adminPassword == "" {
+		return fmt.Errorf("a password of the admin username is required")
+	}
+	if *a.usersFilepath != "" {
+		return a.validateMultiUser()
+	}
 	if err := a.Args.Validate(); err != nil {
 		return err
 	}
+	if *a.htpasswdFilepath != "" {
+		return a.validateTLS()
+	}
 	switch "" {
 	case *a.username, *a.password:
-		s := "a username and password to use for client authentication are required"
+		s := "a username and password (or an htpasswd file) to use for client authentication are required"
+		return fmt.Errorf(s)
+	}
+	return a.validateTLS()
+}
+
+func (a Args) validateMultiUser() error {
+	switch "" {
+	case *a.ClientID:
+		return fmt.Errorf("no client ID provided\n")
+	case *a.Secret:
+		return fmt.Errorf("no client secret provided\n")
+	case *a.tokensDirpath:
+		s := "a directory of tokens of the users of the users file is required"
 		return fmt.Errorf(s)
+	}
+	return a.validateTLS()
+}
+
+func (a Args) validateTLS() error {
+	switch "" {
 	case *a.certFilepath, *a.keyFilepath:
 		if *a.useFCGI || *a.useHTTP {
 			break
@@ -65,7 +136,146 @@ func (a Args) Validate() error {
 	return nil
 }
 
+// loadUsersFromArgs loads the users of the users file, or else those of
+// the htpasswd file or the single username and password, which all use the
+// Fitbit tokens of the single tokens file.
+func loadUsersFromArgs(a Args) (map[string]User, error) {
+	hashes := make(map[string]string)
+	if *a.htpasswdFilepath != "" {
+		var err error
+		if hashes, err = loadHtpasswd(*a.htpasswdFilepath); err != nil {
+			return nil, err
+		}
+	}
+	users := make(map[string]User)
+	switch {
+	case *a.usersFilepath != "":
+		var err error
+		if users, err = loadUsers(*a.usersFilepath); err != nil {
+			return nil, err
+		}
+	case *a.htpasswdFilepath != "":
+		for name := range hashes {
+			users[name] = User{Username: name}
+		}
+	default:
+		users[*a.username] = User{Username: *a.username, Password: *a.password}
+	}
+	if err := withPasswordHashes(users, hashes); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// server proxies the requests of each authenticated user to the Fitbit API,
+// authorized by the OAuth2 tokens of the Fitbit account the user maps to.
+type server struct {
+	// mu guards the users and policies, which are reloaded on SIGHUP.
+	mu       sync.RWMutex
+	users    map[string]User
+	manager  *bitfit.Manager
+	client   *bitfit.Client
+	apiProxy *httputil.ReverseProxy
+	throttle *throttle
+	// keys are the API keys users may authenticate with, if any.
+	keys *keyStore
+	// policies restrict the requests of users and API keys by name.
+	policies map[string]Policy
+	// cache caches responses to GET requests, if enabled.
+	cache *cache.Transport
+	// governor holds back low priority requests as quota runs out, and
+	// coalescer makes identical requests in flight as one.
+	governor  *governor
+	coalescer *coalescer
+	metrics   *metrics
+	health    *health
+	// accessLog logs each request and auditLog logs failures to
+	// authenticate and refreshes of tokens, each as lines of JSON.
+	accessLog *jsonLog
+	auditLog  *jsonLog
+	// metricsUser is the user who may scrape metrics, if any may.
+	metricsUser *User
+	// authorizer authorizes the Fitbit API again for an admin, if any may.
+	authorizer *authorizer
+}
+
+func newServer(baseURL string, users map[string]User, m *bitfit.Manager, c *bitfit.Client) (*server, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	s := &server{
+		users:    users,
+		manager:  m,
+		client:   c,
+		throttle: newThrottle(5, 15*time.Minute, 15*time.Minute),
+		metrics:  newMetrics(),
+		health:   newHealth(),
+	}
+	s.apiProxy = httputil.NewSingleHostReverseProxy(u)
+	s.coalescer = newCoalescer(upstreamTransport{clientTransport{}, s}, requestKey)
+	s.governor = newGovernor(s.coalescer)
+	s.apiProxy.Transport = s.governor
+	d := s.apiProxy.Director
+	s.apiProxy.Director = func(r *http.Request) {
+		d(r)
+		r.Host = u.Host
+	}
+	return s, nil
+}
+
+type clientContextKey struct{}
+
+// accountContextKey is that of the Fitbit user ID of the client of a
+// request, which is empty for the client of the single tokens file.
+type accountContextKey struct{}
+
+// requestKey identifies the request of a URL of a Fitbit account, in the
+// units and locale it requests.
+func requestKey(r *http.Request) string {
+	id, _ := r.Context().Value(accountContextKey{}).(string)
+	return cache.Key(id, r)
+}
+
+// useCache caches the responses to GET requests of each Fitbit account in
+// the backend, so that requests hit in the cache do not spend quota.
+func (s *server) useCache(b cache.Backend) {
+	s.cache = cache.NewTransport(s.governor, b)
+	s.cache.Key = requestKey
+	s.apiProxy.Transport = s.cache
+}
+
+// clientTransport makes each request with the bitfit.Client set in the
+// context of the request, so that it is authorized by that client's tokens.
+type clientTransport struct{}
+
+func (clientTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c, ok := r.Context().Value(clientContextKey{}).(*bitfit.Client)
+	if !ok {
+		return nil, fmt.Errorf("no client is set in the context of the request to '%v'", r.URL)
+	}
+	return c.RoundTrip(r)
+}
+
+// clientFor returns the client of the Fitbit account of the user, which is
+// that of the single tokens file unless the user maps to a Fitbit user ID.
+func (s *server) clientFor(u User) (*bitfit.Client, error) {
+	if u.FitbitUserID == "" {
+		if s.client == nil {
+			return nil, fmt.Errorf("user '%v' has no Fitbit user ID", u.Username)
+		}
+		return s.client, nil
+	}
+	return s.manager.Client(u.FitbitUserID)
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		if err := runKeys(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	// TODO(aoeu): See if env vars can always be used on FastCGI server, remove hardcoded config path.
 	fs, args := setupFlagsAndArgs("args.json")
 	if err := bitfit.ParseFlagSet(fs); err != nil {
@@ -74,43 +284,159 @@ func main() {
 	if err := args.Validate(); err != nil {
 		log.Fatal(err)
 	}
-	if err := bitfit.Init(*args.ClientID, *args.Secret, *args.TokensFilepath); err != nil {
+
+	users, err := loadUsersFromArgs(args)
+	if err != nil {
 		log.Fatal(err)
 	}
-	username, password = *args.username, *args.password
-
-	u, err := url.Parse(bitfit.BaseURL)
+	s, err := newServer(bitfit.BaseURL, users, nil, nil)
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	apiProxy = httputil.NewSingleHostReverseProxy(u)
-	apiProxy.Transport = bitfit.DefaultClient
-	d := apiProxy.Director
-	apiProxy.Director = func(r *http.Request) {
-		d(r)
-		r.Host = u.Host
+	if s.accessLog, err = openLog(*args.accessLogpath); err != nil {
+		log.Fatal(err)
 	}
-
-	http.HandleFunc("/", handleAPICall)
-	switch {
-	case *args.useFCGI:
-		if err := fcgi.Serve(nil, apiProxy); err != nil {
-			log.Fatal(err)
+	if s.auditLog, err = openLog(*args.auditLogpath); err != nil {
+		log.Fatal(err)
+	}
+	switch *args.usersFilepath {
+	case "":
+		c := bitfit.NewClient(*args.ClientID, *args.Secret, *args.TokensFilepath)
+		c.Refreshed = func(t bitfit.Tokens, err error) {
+			s.tokensRefreshed(accountLabel(""), err)
 		}
-	case *args.useHTTP:
-		if err := http.ListenAndServe(*args.port, apiProxy); err != nil {
-			log.Fatal(err)
+		if err := c.Init(); err != nil {
+			log.Fatalf("could not initialize client: %v", err)
 		}
+		bitfit.DefaultClient, s.client = c, c
 	default:
-		p, c, k := *args.port, *args.certFilepath, *args.keyFilepath
-		if err := http.ListenAndServeTLS(p, c, k, apiProxy); err != nil {
+		m := bitfit.NewManager(*args.ClientID, *args.Secret, bitfit.DirTokenStore(*args.tokensDirpath))
+		m.Refreshed = func(userID string, t bitfit.Tokens, err error) {
+			s.tokensRefreshed(accountLabel(userID), err)
+		}
+		s.manager = m
+		if err := m.LoadAll(); err != nil {
+			log.Println(err)
+		}
+	}
+	if *args.metricsUsername != "" {
+		s.metricsUser = &User{Username: *args.metricsUsername, Password: *args.metricsPassword}
+	}
+	if *args.adminUsername != "" {
+		s.authorizer = newAuthorizer(User{Username: *args.adminUsername, Password: *args.adminPassword}, *args.ClientID, *args.Secret)
+		s.authorizer.RedirectURL, s.authorizer.Scopes = *args.redirectURL, strings.Fields(*args.authScopes)
+	}
+	s.throttle.MaxFailures, s.throttle.Lockout = *args.maxFailures, *args.lockout
+	s.governor.Reserve, s.governor.MaxWait = *args.reserveQuota, *args.quotaWait
+	if s.policies, err = loadPoliciesFromArgs(args, users); err != nil {
+		log.Fatal(err)
+	}
+	switch {
+	case *args.cacheDirpath != "":
+		s.useCache(cache.DirBackend(*args.cacheDirpath))
+	case *args.useCache:
+		s.useCache(cache.NewMemoryBackend(10000))
+	}
+	if *args.keysFilepath != "" {
+		if s.keys, err = openKeyStore(*args.keysFilepath); err != nil {
 			log.Fatal(err)
 		}
 	}
+	if err := s.serve(args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/metrics" && s.metricsUser != nil:
+		s.handleMetrics(w, r)
+		return
+	case r.URL.Path == "/authorize" && s.authorizer != nil:
+		s.handleAuthorize(w, r)
+		return
+	case r.URL.Path == "/callback" && s.authorizer != nil:
+		s.handleCallback(w, r)
+		return
+	case r.URL.Path == "/healthz":
+		s.handleHealth(w, r, false)
+		return
+	case r.URL.Path == "/readyz":
+		s.handleHealth(w, r, true)
+		return
+	}
+	start := time.Now()
+	r, info := withRequestInfo(r)
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	s.handleAPICall(sw, r)
+	s.metrics.countRequest(r.URL.Path, sw.status)
+	s.logAccess(r, sw, info, start)
+}
+
+// statusWriter keeps the status and the number of bytes of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush flushes the response, as the reverse proxy does while streaming.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *server) handleAPICall(w http.ResponseWriter, r *http.Request) {
+	cl, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !s.authorize(w, r, cl) {
+		return
+	}
+	c, err := s.clientFor(cl.User)
+	if err != nil {
+		s := fmt.Sprintf("could not get Fitbit client of user '%v': %v", cl.User.Username, err)
+		writeResp(w, http.StatusBadGateway, s)
+		return
+	}
+	ctx := context.WithValue(r.Context(), clientContextKey{}, c)
+	ctx = context.WithValue(ctx, accountContextKey{}, cl.User.FitbitUserID)
+	ctx = context.WithValue(ctx, lowPriorityContextKey{}, strings.EqualFold(r.Header.Get(PriorityHeader), "low"))
+	r = r.WithContext(ctx)
+	r.Header.Del(PriorityHeader)
+	// The caller's own credentials are for the proxy, not the Fitbit API.
+	r.Header.Del("Authorization")
+	s.apiProxy.ServeHTTP(w, r)
+}
+
+// caller is an authenticated user, and the API key they authenticated
+// with if they did not do so by basic auth.
+type caller struct {
+	User User
+	Key  *APIKey
 }
 
-func handleAPICall(w http.ResponseWriter, r *http.Request) {
+// authenticate authenticates the user of a request by basic auth or by an
+// API key as a bearer token, and otherwise responds with why they could not
+// be. Usernames and remote addresses that fail too many times are locked
+// out for a while.
+func (s *server) authenticate(w http.ResponseWriter, r *http.Request) (caller, bool) {
+	if a := r.Header.Get("Authorization"); strings.HasPrefix(a, "Bearer ") {
+		return s.authenticateKey(w, r, strings.TrimPrefix(a, "Bearer "))
+	}
 	u, p, ok := r.BasicAuth()
 	authErr := ""
 	switch {
@@ -120,14 +446,129 @@ func handleAPICall(w http.ResponseWriter, r *http.Request) {
 		authErr = "username in basic authentication is required (RFC 7617)"
 	case p == "":
 		authErr = "password in basic authentication is required (RFC 7617)"
-	case u != username || p != password:
-		authErr = "incorrect username or password"
 	}
 	if authErr != "" {
 		writeResp(w, http.StatusUnauthorized, authErr)
-		return
+		return caller{}, false
+	}
+	keys := []string{"user " + u, "address " + remoteHost(r)}
+	if s.lockedOut(w, keys...) {
+		s.auditAuthFailure(r, u, "", "locked out")
+		return caller{}, false
+	}
+	users, _ := s.config()
+	user, known := users[u]
+	if !known {
+		checkPassword(dummyHash, p)
+	}
+	if !known || !user.authenticate(p) {
+		s.throttle.fail(keys...)
+		s.auditAuthFailure(r, u, "", "incorrect username or password")
+		writeResp(w, http.StatusUnauthorized, "incorrect username or password")
+		return caller{}, false
+	}
+	s.throttle.succeed(keys[0])
+	cl := caller{User: user}
+	infoOf(r).setCaller(cl)
+	return cl, true
+}
+
+func (s *server) authenticateKey(w http.ResponseWriter, r *http.Request, token string) (caller, bool) {
+	if s.keys == nil {
+		writeResp(w, http.StatusUnauthorized, "API keys are not accepted, use basic HTTP authentication (RFC 7617)")
+		return caller{}, false
+	}
+	addr := "address " + remoteHost(r)
+	if s.lockedOut(w, addr) {
+		s.auditAuthFailure(r, "", token, "locked out")
+		return caller{}, false
+	}
+	k, err := s.keys.lookup(token, time.Now())
+	if err != nil {
+		s.throttle.fail(addr)
+		s.auditAuthFailure(r, "", token, err.Error())
+		writeResp(w, http.StatusUnauthorized, err.Error())
+		return caller{}, false
+	}
+	users, _ := s.config()
+	user, ok := users[k.Username]
+	if !ok {
+		ss := fmt.Sprintf("user '%v' of API key '%v' does not exist", k.Username, k.ID)
+		s.auditAuthFailure(r, k.Username, token, ss)
+		writeResp(w, http.StatusUnauthorized, ss)
+		return caller{}, false
+	}
+	cl := caller{User: user, Key: &k}
+	infoOf(r).setCaller(cl)
+	return cl, true
+}
+
+// authorize responds with Forbidden unless the policies of the caller's
+// user and API key, and the scopes of the key, allow the request, and
+// reports whether they do.
+func (s *server) authorize(w http.ResponseWriter, r *http.Request, cl caller) bool {
+	_, policies := s.config()
+	if name, ok := policyOf(cl.User, policies); ok && !policies[name].allows(r.Method, r.URL.Path) {
+		ss := "user '%v' may not %v '%v' under policy '%v'"
+		writeResp(w, http.StatusForbidden, fmt.Sprintf(ss, cl.User.Username, r.Method, r.URL.Path, name))
+		return false
+	}
+	k := cl.Key
+	if k == nil {
+		return true
+	}
+	if p, ok := policies[k.Policy]; k.Policy != "" && (!ok || !p.allows(r.Method, r.URL.Path)) {
+		ss := "API key '%v' may not %v '%v' under policy '%v'"
+		writeResp(w, http.StatusForbidden, fmt.Sprintf(ss, k.ID, r.Method, r.URL.Path, k.Policy))
+		return false
+	}
+	if !k.allows(r.URL.String()) {
+		ss := "API key '%v' is restricted to the scopes '%v' but '%v' requires the scope '%v'"
+		s := fmt.Sprintf(ss, k.ID, strings.Join(k.Scopes, " "), r.URL.Path, bitfit.RequiredScope(r.URL.String()))
+		writeResp(w, http.StatusForbidden, s)
+		return false
+	}
+	return true
+}
+
+// lockedOut responds with when to try again if any of the keys of the
+// throttle are locked out, and reports whether any was.
+func (s *server) lockedOut(w http.ResponseWriter, keys ...string) bool {
+	d, locked := s.throttle.locked(keys...)
+	if locked {
+		w.Header().Set("Retry-After", strconv.Itoa(int(d.Seconds()+1)))
+		writeResp(w, http.StatusTooManyRequests, "too many failed attempts to authenticate, try again later")
+	}
+	return locked
+}
+
+// authenticateAs authenticates a request as a user of a realm apart from
+// the users of the proxy, such as that of metrics, throttling failures by
+// remote address.
+func (s *server) authenticateAs(w http.ResponseWriter, r *http.Request, u *User, realm string) bool {
+	name, p, ok := r.BasicAuth()
+	addr := realm + " address " + remoteHost(r)
+	if s.lockedOut(w, addr) {
+		return false
+	}
+	if !ok || name != u.Username || !u.authenticate(p) {
+		s.throttle.fail(addr)
+		reason := "incorrect username or password for " + realm
+		s.auditAuthFailure(r, name, "", reason)
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%v"`, realm))
+		writeResp(w, http.StatusUnauthorized, reason)
+		return false
+	}
+	return true
+}
+
+// remoteHost returns the host of the remote address of a request.
+func remoteHost(r *http.Request) string {
+	h, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
 	}
-	apiProxy.ServeHTTP(w, r)
+	return h
 }
 
 func writeResp(w http.ResponseWriter, code int, message string) {
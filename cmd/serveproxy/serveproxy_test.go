@@ -0,0 +1,829 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aoeu/bitfit"
+	"github.com/aoeu/bitfit/cache"
+	"github.com/aoeu/bitfit/proxy"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newTestServer starts a proxy of the users to an upstream that responds
+// with the Authorization header it was sent by the proxy.
+func newTestServer(t *testing.T, users map[string]User) (*httptest.Server, *server) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("Authorization"))
+	}))
+	t.Cleanup(upstream.Close)
+	c := &bitfit.Client{
+		Authorizer: func(r *http.Request) error {
+			r.Header.Set("Authorization", "Bearer FOO")
+			return nil
+		},
+	}
+	s, err := newServer(upstream.URL, users, nil, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	return ts, s
+}
+
+func get(t *testing.T, url, username, password string) (*http.Response, string) {
+	req, err := http.NewRequest("GET", url+"/1.2/user/-/sleep/date/2019-09-16.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(b)
+}
+
+func hashArgon2(password string) string {
+	salt := []byte("0123456789abcdef")
+	k := argon2.IDKey([]byte(password), salt, 1, 64, 1, 32)
+	s := "$argon2id$v=%d$m=64,t=1,p=1$%v$%v"
+	return fmt.Sprintf(s, argon2.Version, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(k))
+}
+
+func TestAuthenticatingWithHashes(t *testing.T) {
+	b, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, _ := newTestServer(t, map[string]User{
+		"alice": {Username: "alice", PasswordHash: string(b)},
+		"bob":   {Username: "bob", PasswordHash: hashArgon2("swordfish")},
+		"carol": {Username: "carol", Password: "plain"},
+	})
+	errFmt := "expected %v but received %v"
+	tests := []struct {
+		username, password string
+		status             int
+	}{
+		{"alice", "hunter2", http.StatusOK},
+		{"alice", "hunter3", http.StatusUnauthorized},
+		{"bob", "swordfish", http.StatusOK},
+		{"bob", "hunter2", http.StatusUnauthorized},
+		{"carol", "plain", http.StatusOK},
+		{"carol", "plai", http.StatusUnauthorized},
+		{"dave", "hunter2", http.StatusUnauthorized},
+		{"", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		resp, body := get(t, ts.URL, tt.username, tt.password)
+		if e, a := tt.status, resp.StatusCode; e != a {
+			t.Fatalf(errFmt, e, a)
+		}
+		if tt.status == http.StatusOK {
+			if e, a := "Bearer FOO", body; e != a {
+				t.Fatalf(errFmt, e, a)
+			}
+		}
+	}
+}
+
+func TestLockingOutAfterFailures(t *testing.T) {
+	ts, _ := newTestServer(t, map[string]User{
+		"alice": {Username: "alice", Password: "hunter2"},
+	})
+	errFmt := "expected %v but received %v"
+	for i := 0; i < 5; i++ {
+		resp, _ := get(t, ts.URL, "alice", "wrong")
+		if e, a := http.StatusUnauthorized, resp.StatusCode; e != a {
+			t.Fatalf(errFmt, e, a)
+		}
+	}
+	resp, _ := get(t, ts.URL, "alice", "hunter2")
+	if e, a := http.StatusTooManyRequests, resp.StatusCode; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Fatal("expected a Retry-After header while locked out")
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	now := time.Date(2019, 9, 16, 0, 0, 0, 0, time.UTC)
+	th := newThrottle(2, time.Minute, time.Hour)
+	th.now = func() time.Time { return now }
+	errFmt := "expected %v but received %v"
+
+	th.fail("a")
+	if _, locked := th.locked("a"); locked {
+		t.Fatal("expected one failure not to lock out")
+	}
+	now = now.Add(2 * time.Minute)
+	th.fail("a")
+	if _, locked := th.locked("a"); locked {
+		t.Fatal("expected failures outside of the window to be forgotten")
+	}
+	th.fail("a", "b")
+	d, locked := th.locked("b", "a")
+	if !locked {
+		t.Fatal("expected two failures within the window to lock out")
+	}
+	if e, a := time.Hour, d; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	th.succeed("a")
+	if _, locked := th.locked("a"); !locked {
+		t.Fatal("expected success not to end a lockout")
+	}
+	now = now.Add(time.Hour)
+	if _, locked := th.locked("a", "b"); locked {
+		t.Fatal("expected the lockout to end")
+	}
+}
+
+func TestLoadingHtpasswd(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "htpasswd")
+	b, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := fmt.Sprintf("# users\nalice:%s\n\nbob:%s\n", b, hashArgon2("swordfish"))
+	if err := ioutil.WriteFile(p, []byte(s), 0600); err != nil {
+		t.Fatal(err)
+	}
+	m, err := loadHtpasswd(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errFmt := "expected %v but received %v"
+	if e, a := 2, len(m); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if !checkPassword(m["alice"], "hunter2") || !checkPassword(m["bob"], "swordfish") {
+		t.Fatal("expected passwords to match their loaded hashes")
+	}
+	if err := ioutil.WriteFile(p, []byte("carol:{SHA}abc\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadHtpasswd(p); err == nil {
+		t.Fatal("expected an error for a hash that is neither bcrypt nor argon2")
+	}
+}
+
+func TestAuthenticatingWithAPIKeys(t *testing.T) {
+	ts, s := newTestServer(t, map[string]User{
+		"alice": {Username: "alice", Password: "hunter2"},
+	})
+	var err error
+	if s.keys, err = openKeyStore(filepath.Join(t.TempDir(), "keys.json")); err != nil {
+		t.Fatal(err)
+	}
+	k, token, err := s.keys.create("alice", "laptop", []string{"sleep"}, "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, expired, err := s.keys.create("alice", "old", nil, "", -time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errFmt := "expected %v but received %v"
+	tests := []struct {
+		token, path string
+		status      int
+	}{
+		{token, "/1.2/user/-/sleep/date/2019-09-16.json", http.StatusOK},
+		{token, "/1/user/-/activities/heart/date/today/1d.json", http.StatusForbidden},
+		{token + "x", "/1.2/user/-/sleep/date/2019-09-16.json", http.StatusUnauthorized},
+		{expired, "/1.2/user/-/sleep/date/2019-09-16.json", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		resp, err := proxy.NewKeyClient(tt.token).Get(ts.URL + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if e, a := tt.status, resp.StatusCode; e != a {
+			t.Fatalf(errFmt, e, a)
+		}
+	}
+	if err := s.keys.revoke(k.ID); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := proxy.NewKeyClient(token).Get(ts.URL + "/1.2/user/-/sleep/date/2019-09-16.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if e, a := http.StatusUnauthorized, resp.StatusCode; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	readSleep := Policy{{
+		Methods: []string{"GET"},
+		Paths:   []string{"/1.2/user/-/sleep/", "/1/user/-/activities/heart/date/*/1d.json"},
+	}}
+	errFmt := "expected %v but received %v"
+	tests := []struct {
+		method, path string
+		allowed      bool
+	}{
+		{"GET", "/1.2/user/-/sleep/date/2019-09-16.json", true},
+		{"get", "/1.2/user/-/sleep/list.json", true},
+		{"DELETE", "/1.2/user/-/sleep/26589710670.json", false},
+		{"GET", "/1/user/-/activities/heart/date/today/1d.json", true},
+		{"GET", "/1/user/-/activities/heart/date/today/7d.json", false},
+		{"GET", "/1/user/-/foods/log/date/2019-09-16.json", false},
+		{"GET", "/1.2/user/-/sleep/../../../1/user/-/foods/log.json", false},
+	}
+	for _, tt := range tests {
+		if e, a := tt.allowed, readSleep.allows(tt.method, tt.path); e != a {
+			t.Fatalf(errFmt, e, a)
+		}
+	}
+
+	ts, s := newTestServer(t, map[string]User{
+		"alice": {Username: "alice", Password: "hunter2", Policy: "readsleep"},
+		"bob":   {Username: "bob", Password: "swordfish"},
+	})
+	s.policies = map[string]Policy{"readsleep": readSleep}
+	resp, _ := get(t, ts.URL, "alice", "hunter2")
+	if e, a := http.StatusOK, resp.StatusCode; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	req, err := http.NewRequest("POST", ts.URL+"/1.2/user/-/sleep.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("alice", "hunter2")
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if e, a := http.StatusForbidden, resp.StatusCode; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	req.SetBasicAuth("bob", "swordfish")
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if e, a := http.StatusOK, resp.StatusCode; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+}
+
+func TestCachingPerAccount(t *testing.T) {
+	ts, s := newTestServer(t, map[string]User{
+		"alice": {Username: "alice", Password: "hunter2"},
+	})
+	s.useCache(cache.NewMemoryBackend(0))
+	errFmt := "expected %v but received %v"
+	for _, e := range []string{"MISS", "HIT"} {
+		resp, _ := get(t, ts.URL, "alice", "hunter2")
+		if a := resp.Header.Get(cache.HitHeader); e != a {
+			t.Fatalf(errFmt, e, a)
+		}
+	}
+	if e, a := (cache.Stats{Hits: 1, Misses: 1}), s.cache.Stats(); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	// A request in other units is not responded to with the cached response.
+	req, err := http.NewRequest("GET", ts.URL+"/1.2/user/-/sleep/date/2019-09-16.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("alice", "hunter2")
+	req.Header.Set("Accept-Language", "en_US")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if e, a := "MISS", resp.Header.Get(cache.HitHeader); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+}
+
+func TestCoalescingRequests(t *testing.T) {
+	var requests int32
+	arrived, release := make(chan struct{}), make(chan struct{})
+	next := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			close(arrived)
+		}
+		<-release
+		return &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Body: ioutil.NopCloser(strings.NewReader("FOO"))}, nil
+	})
+	c := newCoalescer(next, requestKey)
+	errFmt := "expected %v but received %v"
+	var wg sync.WaitGroup
+	do := func() {
+		defer wg.Done()
+		req := httptest.NewRequest("GET", "https://api.fitbit.com/1.2/user/-/sleep/date/2019-09-16.json", nil)
+		resp, err := c.RoundTrip(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		if e, a := "FOO", string(b); e != a {
+			t.Errorf(errFmt, e, a)
+		}
+	}
+	wg.Add(5)
+	go do()
+	<-arrived
+	for i := 0; i < 4; i++ {
+		go do()
+	}
+	// Let the other requests coalesce into the first before it is done.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if e, a := int32(1), atomic.LoadInt32(&requests); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+}
+
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestGoverningQuota(t *testing.T) {
+	reset := "3600"
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Fitbit-Rate-Limit-Limit", "150")
+		w.Header().Set("Fitbit-Rate-Limit-Remaining", "5")
+		w.Header().Set("Fitbit-Rate-Limit-Reset", reset)
+	}))
+	defer upstream.Close()
+	c := &bitfit.Client{Authorizer: func(r *http.Request) error { return nil }}
+	g := newGovernor(clientTransport{})
+	g.Reserve = 10
+	do := func(low bool) *http.Response {
+		req := httptest.NewRequest("GET", upstream.URL+"/1.2/user/-/sleep/date/2019-09-16.json", nil)
+		req.RequestURI = ""
+		ctx := context.WithValue(req.Context(), clientContextKey{}, c)
+		ctx = context.WithValue(ctx, lowPriorityContextKey{}, low)
+		resp, err := g.RoundTrip(req.WithContext(ctx))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	errFmt := "expected %v but received %v"
+	tests := []struct {
+		low    bool
+		status int
+	}{
+		// The quota is unknown until the first response.
+		{true, http.StatusOK},
+		{true, http.StatusTooManyRequests},
+		{false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if e, a := tt.status, do(tt.low).StatusCode; e != a {
+			t.Fatalf(errFmt, e, a)
+		}
+	}
+	reset = "0"
+	do(false)
+	if e, a := http.StatusOK, do(true).StatusCode; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	errFmt := "expected %v but received %v"
+	tests := map[string]string{
+		"/1.2/user/-/sleep/date/2019-09-16.json":             "/1.2/user/-/sleep/date/{date}.json",
+		"/1/user/BAZ/activities/heart/date/today/1d.json":    "/1/user/{user}/activities/heart/date/today/1d.json",
+		"/1.2/user/-/sleep/26589710670.json":                 "/1.2/user/-/sleep/{id}.json",
+		"/1/user/-/body/log/weight/date/2019-09-01/30d.json": "/1/user/-/body/log/weight/date/{date}/30d.json",
+		"/favicon.ico": "other",
+	}
+	for path, e := range tests {
+		if a := route(path); e != a {
+			t.Fatalf(errFmt, e, a)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	ts, s := newTestServer(t, map[string]User{
+		"alice": {Username: "alice", Password: "hunter2"},
+	})
+	s.metricsUser = &User{Username: "prometheus", Password: "scrape"}
+	s.client.Tokens.Expiration = time.Now().Add(time.Hour)
+	s.metrics.refreshed("default", nil)
+	s.metrics.refreshed("default", fmt.Errorf("invalid_grant"))
+	get(t, ts.URL, "alice", "hunter2")
+	get(t, ts.URL, "alice", "wrong")
+
+	errFmt := "expected %v but received %v"
+	req, err := http.NewRequest("GET", ts.URL+"/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("alice", "hunter2")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if e, a := http.StatusUnauthorized, resp.StatusCode; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	req.SetBasicAuth("prometheus", "scrape")
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []string{
+		`bitfit_proxy_requests_total{path="/1.2/user/-/sleep/date/{date}.json",status="200"} 1`,
+		`bitfit_proxy_requests_total{path="/1.2/user/-/sleep/date/{date}.json",status="401"} 1`,
+		`bitfit_upstream_latency_seconds_count{path="/1.2/user/-/sleep/date/{date}.json"} 1`,
+		`bitfit_token_refreshes_total{account="default"} 2`,
+		`bitfit_token_refresh_failures_total{account="default"} 1`,
+		`bitfit_token_expiry_seconds{account="default"} 3`,
+	} {
+		if !strings.Contains(string(b), e) {
+			t.Fatalf(errFmt, e, string(b))
+		}
+	}
+}
+
+func TestRedacting(t *testing.T) {
+	errFmt := "expected %v but received %v"
+	tests := map[string]string{
+		"Authorization: Bearer eyJhbGciOi.J9":                   "Authorization: Bearer [REDACTED]",
+		`{"access_token":"abc","refresh_token": "def","x":"y"}`: `{"access_token":"[REDACTED]","refresh_token":"[REDACTED]","x":"y"}`,
+		"grant_type=refresh_token&refresh_token=def":            "grant_type=refresh_token&refresh_token=[REDACTED]",
+		"incorrect API key bfk_0123abcd_c2VjcmV0":               "incorrect API key bfk_[REDACTED]",
+		"invalid_grant: Refresh token invalid: abc":             "invalid_grant: Refresh token invalid: abc",
+	}
+	for s, e := range tests {
+		if a := redact(s); e != a {
+			t.Fatalf(errFmt, e, a)
+		}
+	}
+}
+
+func TestLogging(t *testing.T) {
+	ts, s := newTestServer(t, map[string]User{
+		"alice": {Username: "alice", Password: "hunter2"},
+	})
+	access, audit := &bytes.Buffer{}, &bytes.Buffer{}
+	s.accessLog, s.auditLog = &jsonLog{w: access}, &jsonLog{w: audit}
+	get(t, ts.URL, "alice", "hunter2")
+	get(t, ts.URL, "alice", "hunter3")
+	s.tokensRefreshed("default", fmt.Errorf(`{"refresh_token":"s3cr3t"}`))
+
+	errFmt := "expected %v but received %v"
+	var a []accessEntry
+	for _, line := range strings.Split(strings.TrimSpace(access.String()), "\n") {
+		e := accessEntry{}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		a = append(a, e)
+	}
+	if e, aa := 2, len(a); e != aa {
+		t.Fatalf(errFmt, e, aa)
+	}
+	if e, aa := "alice", a[0].Caller; e != aa {
+		t.Fatalf(errFmt, e, aa)
+	}
+	if e, aa := int64(len("Bearer FOO")), a[0].Bytes; e != aa {
+		t.Fatalf(errFmt, e, aa)
+	}
+	if e, aa := http.StatusUnauthorized, a[1].Status; e != aa {
+		t.Fatalf(errFmt, e, aa)
+	}
+	for _, secret := range []string{"hunter", "s3cr3t", "FOO"} {
+		if strings.Contains(access.String()+audit.String(), secret) {
+			t.Fatalf("expected '%v' to be redacted from the logs:\n%v%v", secret, access, audit)
+		}
+	}
+	for _, e := range []string{`"event":"auth_failure","caller":"alice"`, `"event":"token_refresh_failure","account":"default"`} {
+		if !strings.Contains(audit.String(), e) {
+			t.Fatalf(errFmt, e, audit)
+		}
+	}
+}
+
+func TestHealth(t *testing.T) {
+	ts, s := newTestServer(t, map[string]User{
+		"alice": {Username: "alice", Password: "hunter2"},
+	})
+	s.metricsUser = &User{Username: "prometheus", Password: "scrape"}
+	errFmt := "expected %v but received %v"
+	probe := func(path, username, password string) (int, string) {
+		req, err := http.NewRequest("GET", ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(b)
+	}
+	probeStatus := func(path string) int {
+		code, _ := probe(path, "", "")
+		return code
+	}
+	if e, a := http.StatusServiceUnavailable, probeStatus("/readyz"); e != a {
+		t.Fatalf(errFmt+" before tokens are loaded", e, a)
+	}
+
+	s.client.Tokens = bitfit.Tokens{Access: "foo", Refresh: "bar", Expiration: time.Now().Add(-time.Minute)}
+	get(t, ts.URL, "alice", "hunter2")
+	code, body := probe("/readyz", "", "")
+	if e, a := http.StatusOK, code; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if strings.Contains(body, "default") {
+		t.Fatalf("expected the health of each account to require authentication but received %v", body)
+	}
+	if code, _ = probe("/readyz", "prometheus", "wrong"); code != http.StatusUnauthorized {
+		t.Fatalf(errFmt, http.StatusUnauthorized, code)
+	}
+	_, body = probe("/readyz", "prometheus", "scrape")
+	for _, e := range []string{`"expired": true`, `"refreshable": true`, `"last_upstream_success"`} {
+		if !strings.Contains(body, e) {
+			t.Fatalf(errFmt, e, body)
+		}
+	}
+
+	s.tokensRefreshed("default", fmt.Errorf("invalid_grant"))
+	if e, a := http.StatusServiceUnavailable, probeStatus("/readyz"); e != a {
+		t.Fatalf(errFmt+" once the refresh token is invalid", e, a)
+	}
+	if e, a := http.StatusOK, probeStatus("/healthz"); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+
+	// A failed request upstream is reported, but is not for the proxy to be
+	// taken out of rotation for, and a caller hanging up is not a failure.
+	s.tokensRefreshed("default", nil)
+	s.health.upstream("default", &http.Response{StatusCode: http.StatusUnauthorized, Status: "401 Unauthorized"}, nil)
+	s.health.upstream("default", nil, fmt.Errorf("could not round trip: %w", context.Canceled))
+	code, body = probe("/readyz", "prometheus", "scrape")
+	if e, a := http.StatusOK, code; e != a {
+		t.Fatalf(errFmt+" once a request upstream fails", e, a)
+	}
+	if e := `"last_upstream_error": "401 Unauthorized"`; !strings.Contains(body, e) {
+		t.Fatalf(errFmt, e, body)
+	}
+
+	// One account that is not ready does not make the proxy unready.
+	if !ready([]accountStatus{{Account: "BAZ", Ready: false}, {Account: "QUX", Ready: true}}) {
+		t.Fatal("expected the proxy to be ready while any account is")
+	}
+}
+
+func TestReloading(t *testing.T) {
+	ts, s := newTestServer(t, nil)
+	dir := t.TempDir()
+	htpasswd, policies := filepath.Join(dir, "htpasswd"), filepath.Join(dir, "policies.json")
+	writeFile := func(p, content string) {
+		if err := ioutil.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash := func(password string) string {
+		h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(h)
+	}
+	fs, args := setupFlagsAndArgs("")
+	if err := fs.Parse([]string{"-htpasswd", htpasswd, "-policiesfile", policies}); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(htpasswd, "alice:"+hash("hunter2")+"\n")
+	writeFile(policies, "{}")
+	if err := s.reload(args); err != nil {
+		t.Fatal(err)
+	}
+	errFmt := "expected %v but received %v"
+	if resp, _ := get(t, ts.URL, "alice", "hunter2"); resp.StatusCode != http.StatusOK {
+		t.Fatalf(errFmt, http.StatusOK, resp.StatusCode)
+	}
+
+	writeFile(htpasswd, "bob:"+hash("swordfish")+"\n")
+	writeFile(policies, `{"bob": [{"paths": ["/1/user/-/profile.json"]}]}`)
+	if err := s.reload(args); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := get(t, ts.URL, "alice", "hunter2"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf(errFmt, http.StatusUnauthorized, resp.StatusCode)
+	}
+	if resp, _ := get(t, ts.URL, "bob", "swordfish"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf(errFmt, http.StatusForbidden, resp.StatusCode)
+	}
+
+	writeFile(policies, `{"bob": [{"paths": ["["]}]}`)
+	if err := s.reload(args); err == nil {
+		t.Fatal("expected an invalid policy to fail to reload")
+	}
+	if resp, _ := get(t, ts.URL, "bob", "swordfish"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf(errFmt, http.StatusForbidden, resp.StatusCode)
+	}
+}
+
+func TestFlushingTokens(t *testing.T) {
+	_, s := newTestServer(t, nil)
+	s.client.Tokens = bitfit.Tokens{Access: "FOO", Refresh: "BAR"}
+	var saved bitfit.Tokens
+	s.client.TokensSaver = func(t bitfit.Tokens) error {
+		saved = t
+		return nil
+	}
+	if err := s.flushTokens(); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "FOO BAR", saved.Access+" "+saved.Refresh; e != a {
+		t.Fatalf("expected %v but received %v", e, a)
+	}
+	s.client.TokensSaver = func(bitfit.Tokens) error {
+		return fmt.Errorf("disk full")
+	}
+	if err := s.flushTokens(); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("expected an error of the failure to save tokens but received %v", err)
+	}
+}
+
+func TestAuthorizing(t *testing.T) {
+	ts, s := newTestServer(t, map[string]User{
+		"alice": {Username: "alice", Password: "hunter2"},
+	})
+	var saved bitfit.Tokens
+	s.client.TokensSaver = func(t bitfit.Tokens) error {
+		saved = t
+		return nil
+	}
+	s.authorizer = newAuthorizer(User{Username: "admin", Password: "swordfish"}, "id", "secret")
+	s.authorizer.Exchange = func(code, redirectURL, codeVerifier string) (bitfit.Tokens, error) {
+		if code != "foo" || codeVerifier == "" {
+			return bitfit.Tokens{}, fmt.Errorf("invalid code '%v'", code)
+		}
+		return bitfit.Tokens{Access: "qux", Refresh: "quux", Expiration: time.Now().Add(time.Hour)}, nil
+	}
+	c := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	do := func(path, username, password string) *http.Response {
+		req, err := http.NewRequest("GET", ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth(username, password)
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	errFmt := "expected %v but received %v"
+	if resp := do("/authorize", "alice", "hunter2"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf(errFmt, http.StatusUnauthorized, resp.StatusCode)
+	}
+	resp := do("/authorize", "admin", "swordfish")
+	if e, a := http.StatusFound, resp.StatusCode; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	u, err := resp.Location()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, a := ts.URL+"/callback", u.Query().Get("redirect_uri"); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	state := u.Query().Get("state")
+	if resp := do("/callback?code=foo&state=bar", "admin", "swordfish"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf(errFmt, http.StatusBadRequest, resp.StatusCode)
+	}
+	if resp := do("/callback?code=foo&state="+state, "alice", "hunter2"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf(errFmt, http.StatusUnauthorized, resp.StatusCode)
+	}
+	if resp := do("/callback?code=foo&state="+state, "admin", "swordfish"); resp.StatusCode != http.StatusOK {
+		t.Fatalf(errFmt, http.StatusOK, resp.StatusCode)
+	}
+	if e, a := "qux", s.client.CurrentTokens().Access; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := "quux", saved.Refresh; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	// A state can only be called back with once.
+	if resp := do("/callback?code=foo&state="+state, "admin", "swordfish"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf(errFmt, http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestNotForwardingCredentials(t *testing.T) {
+	ts, s := newTestServer(t, map[string]User{
+		"alice": {Username: "alice", Password: "hunter2"},
+	})
+	// A client that authorizes requests as the bitfit package does, rather
+	// than the stub of newTestServer.
+	c := bitfit.NewClient("id", "secret", "")
+	c.Tokens = bitfit.Tokens{Access: "REAL", Refresh: "BAR", Expiration: time.Now().Add(time.Hour)}
+	s.client = c
+	_, body := get(t, ts.URL, "alice", "hunter2")
+	if e, a := "Bearer REAL", body; e != a {
+		t.Fatalf("expected %v but received %v", e, a)
+	}
+}
+
+func TestHealthWhileRefreshing(t *testing.T) {
+	_, s := newTestServer(t, nil)
+	arrived, release := make(chan struct{}), make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-release
+		fmt.Fprint(w, `{"access_token": "qux", "refresh_token": "quux", "expires_in": 28800}`)
+	}))
+	prev := bitfit.BaseURL
+	bitfit.BaseURL = upstream.URL
+	t.Cleanup(func() {
+		bitfit.BaseURL = prev
+		upstream.Close()
+	})
+	c := bitfit.NewClient("id", "secret", "")
+	c.TokensLoader = func() (bitfit.Tokens, error) {
+		return bitfit.Tokens{Access: "foo", Refresh: "bar", Expiration: time.Now().Add(-time.Hour)}, nil
+	}
+	c.TokensSaver = func(bitfit.Tokens) error { return nil }
+	c.Refreshed = func(t bitfit.Tokens, err error) {
+		s.tokensRefreshed(accountLabel(""), err)
+	}
+	s.client = c
+	refreshed := make(chan error)
+	go func() { refreshed <- c.Init() }()
+	<-arrived
+
+	// The status is requested while the tokens are guarded by the refresh.
+	done := make(chan []accountStatus)
+	go func() { done <- s.status(time.Now()) }()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	timeout := time.After(5 * time.Second)
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-refreshed:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case st := <-done:
+			if e, a := true, st[0].Ready; e != a {
+				t.Fatalf("expected %v but received %v", e, a)
+			}
+		case <-timeout:
+			t.Fatal("expected the status and the refresh not to deadlock")
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// throttle locks out a caller (such as a username or remote address) for a
+// while after too many failed attempts to authenticate within a window.
+type throttle struct {
+	MaxFailures int
+	Window      time.Duration
+	Lockout     time.Duration
+
+	mu       sync.Mutex
+	failures map[string]*failures
+	now      func() time.Time
+}
+
+type failures struct {
+	count       int
+	first       time.Time
+	lockedUntil time.Time
+}
+
+func newThrottle(maxFailures int, window, lockout time.Duration) *throttle {
+	return &throttle{
+		MaxFailures: maxFailures,
+		Window:      window,
+		Lockout:     lockout,
+		failures:    make(map[string]*failures),
+		now:         time.Now,
+	}
+}
+
+// locked returns how much longer the longest lockout of any of the keys
+// lasts, and whether any of them is locked out.
+func (t *throttle) locked(keys ...string) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	var d time.Duration
+	for _, k := range keys {
+		if f, ok := t.failures[k]; ok && f.lockedUntil.After(now) && f.lockedUntil.Sub(now) > d {
+			d = f.lockedUntil.Sub(now)
+		}
+	}
+	return d, d > 0
+}
+
+// fail counts a failed attempt against each of the keys, locking out those
+// that have failed too many times within the window.
+func (t *throttle) fail(keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	t.forget(now)
+	for _, k := range keys {
+		f, ok := t.failures[k]
+		if !ok {
+			f = &failures{first: now}
+			t.failures[k] = f
+		}
+		f.count++
+		if t.MaxFailures > 0 && f.count >= t.MaxFailures {
+			f.lockedUntil = now.Add(t.Lockout)
+			f.count, f.first = 0, now
+		}
+	}
+}
+
+// succeed forgets the failed attempts of each of the keys.
+func (t *throttle) succeed(keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, k := range keys {
+		if f, ok := t.failures[k]; ok && !f.lockedUntil.After(t.now()) {
+			delete(t.failures, k)
+		}
+	}
+}
+
+// forget forgets failures older than the window that are not locked out.
+func (t *throttle) forget(now time.Time) {
+	for k, f := range t.failures {
+		if now.Sub(f.first) > t.Window && !f.lockedUntil.After(now) {
+			delete(t.failures, k)
+		}
+	}
+}
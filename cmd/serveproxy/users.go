@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// User is a user of the proxy, who is authenticated by username and
+// password, and whose requests are authorized by the Fitbit tokens of
+// the Fitbit user ID they map to.
+type User struct {
+	Username string
+	// Password is the user's password in plain text, which is only checked
+	// if the user has no PasswordHash.
+	Password string
+	// PasswordHash is a bcrypt or argon2 hash of the user's password, as
+	// loaded from an htpasswd file.
+	PasswordHash string
+	FitbitUserID string
+	// Policy names the policy that restricts the requests of the user (see
+	// policyOf).
+	Policy string
+}
+
+// authenticate reports whether the password is the user's, in time that
+// does not depend on how much of the password matches.
+func (u User) authenticate(password string) bool {
+	if u.PasswordHash != "" {
+		return checkPassword(u.PasswordHash, password)
+	}
+	// Digests are compared so as not to leak the length of the password.
+	a, b := sha256.Sum256([]byte(u.Password)), sha256.Sum256([]byte(password))
+	return u.Password != "" && subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+// loadUsers loads a JSON file of an array of users, keyed by username.
+// Users without a password or password hash must be given a hash from an
+// htpasswd file (see withPasswordHashes).
+func loadUsers(filepath string) (map[string]User, error) {
+	b, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return nil, fmt.Errorf("filepath of users '%v' could not be read: %v", filepath, err)
+	}
+	var a []User
+	if err := json.Unmarshal(b, &a); err != nil {
+		return nil, fmt.Errorf("could not unmarshal users at filepath '%v': %v", filepath, err)
+	}
+	m := make(map[string]User, len(a))
+	for _, u := range a {
+		switch {
+		case u.Username == "":
+			return nil, fmt.Errorf("a user of '%v' has no username", filepath)
+		case u.FitbitUserID == "":
+			return nil, fmt.Errorf("user '%v' of '%v' has no Fitbit user ID", u.Username, filepath)
+		}
+		if _, ok := m[u.Username]; ok {
+			return nil, fmt.Errorf("user '%v' of '%v' is not unique", u.Username, filepath)
+		}
+		m[u.Username] = u
+	}
+	return m, nil
+}
+
+// withPasswordHashes sets the password hash of each user from those loaded
+// from an htpasswd file, and returns an error for any user that is left
+// without a password or a hash of one.
+func withPasswordHashes(users map[string]User, hashes map[string]string) error {
+	for name, u := range users {
+		if h, ok := hashes[name]; ok {
+			u.PasswordHash = h
+			users[name] = u
+		}
+		if u.Password == "" && u.PasswordHash == "" {
+			return fmt.Errorf("user '%v' has no password or hash of one", name)
+		}
+	}
+	return nil
+}
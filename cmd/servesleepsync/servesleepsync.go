@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aoeu/bitfit"
+	"github.com/aoeu/bitfit/sleepsync"
+	"github.com/aoeu/bitfit/webhook"
+)
+
+type Args struct {
+	bitfit.Args
+	verificationCode *string
+	queueFilepath    *string
+	into             *string
+	as               *string
+	tokensDirpath    *string
+	port             *string
+}
+
+func setupFlagsAndArgs() (*flag.FlagSet, Args) {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	args := Args{
+		Args:             bitfit.ArgsWithFlagSet(fs, ""),
+		verificationCode: fs.String("verify", "", "the verification code of the subscriber endpoint"),
+		queueFilepath:    fs.String("queuefile", "sleep_sync_queue.json", "a JSON file in which to queue sleep logs to download"),
+		into:             fs.String("into", ".", "the path in which to write files of payloads to save"),
+		as:               fs.String("as", "sleep_log_payload", "the filename template to use for saved payloads"),
+		tokensDirpath:    fs.String("tokensdir", "", "a directory of JSON files of each subscriber's tokens, named by Fitbit user ID, instead of the single tokens file"),
+		port:             fs.String("port", ":9191", "the port to serve on"),
+	}
+	return fs, args
+}
+
+func (a Args) Validate() error {
+	if *a.tokensDirpath != "" {
+		switch "" {
+		case *a.ClientID:
+			return fmt.Errorf("no client ID provided\n")
+		case *a.Secret:
+			return fmt.Errorf("no client secret provided\n")
+		}
+	} else if err := a.Args.Validate(); err != nil {
+		return err
+	}
+	switch "" {
+	case *a.verificationCode:
+		return fmt.Errorf("the verification code of the subscriber endpoint is required")
+	case *a.queueFilepath:
+		return fmt.Errorf("a filepath for the queue of sleep logs to download is required")
+	}
+	return nil
+}
+
+func main() {
+	fs, args := setupFlagsAndArgs()
+	if err := bitfit.ParseFlagSet(fs); err != nil {
+		log.Fatal(err)
+	}
+	if err := args.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	p, err := filepath.Abs(*args.into)
+	if err != nil {
+		log.Fatalf("could not get absolute path of %v: %v", *args.into, err)
+	}
+	var fetch func(ownerID string, date time.Time) ([]byte, error)
+	switch *args.tokensDirpath {
+	case "":
+		c, err := newClient(args)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fetch = sleepsync.ClientFetch(c)
+	default:
+		m := bitfit.NewManager(*args.ClientID, *args.Secret, bitfit.DirTokenStore(*args.tokensDirpath))
+		if err := m.LoadAll(); err != nil {
+			log.Println(err)
+		}
+		fetch = sleepsync.ManagerFetch(m)
+	}
+	q, err := sleepsync.OpenQueue(*args.queueFilepath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := sleepsync.NewSyncer(q, sleepsync.DirStore{Dir: p, As: *args.as}, fetch)
+	go s.Run(context.Background())
+
+	http.Handle("/", webhook.NewHandler(*args.verificationCode, *args.Secret, s.Notify))
+	if err := http.ListenAndServe(*args.port, nil); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// newClient initializes the client of the single tokens file, learning the
+// Fitbit user ID of tokens saved before it was kept by introspecting them,
+// since only the sleep logs of that user can be fetched with them.
+func newClient(a Args) (*bitfit.Client, error) {
+	c := bitfit.NewClient(*a.ClientID, *a.Secret, *a.TokensFilepath)
+	if err := c.Init(); err != nil {
+		return nil, err
+	}
+	t := c.CurrentTokens()
+	if t.UserID != "" {
+		return c, nil
+	}
+	i, err := c.Introspect()
+	if err != nil {
+		return nil, fmt.Errorf("could not learn the Fitbit user ID of the tokens: %v", err)
+	}
+	t.UserID = i.UserID
+	if err := c.SetTokens(t); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
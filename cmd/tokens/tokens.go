@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aoeu/bitfit"
+)
+
+var usage = `usage: %v <subcommand> [flags]
+
+subcommands:
+	health	print the health of the tokens of the file referenced by -tokensfile
+	revoke	revoke the tokens of the file referenced by -tokensfile
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintf(os.Stderr, usage, os.Args[0])
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet(os.Args[0]+" "+os.Args[1], flag.ContinueOnError)
+	args := bitfit.ArgsWithFlagSet(fs, "")
+	if err := bitfit.ParseFlagSetArgs(fs, os.Args[2:]); err != nil {
+		log.Fatal(err)
+	}
+	if *args.TokensFilepath == "" {
+		log.Fatal("a tokens filepath is required")
+	}
+	t, err := readTokens(*args.TokensFilepath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch os.Args[1] {
+	case "health":
+		printHealth(t)
+	case "revoke":
+		if err := args.Validate(); err != nil {
+			log.Fatal(err)
+		}
+		if err := bitfit.RevokeToken(*args.ClientID, *args.Secret, t.Refresh); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("revoked tokens of '%v'\n", *args.TokensFilepath)
+	default:
+		fmt.Fprintf(os.Stderr, usage, os.Args[0])
+		os.Exit(2)
+	}
+}
+
+func readTokens(filepath string) (bitfit.Tokens, error) {
+	t := bitfit.Tokens{}
+	b, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return t, fmt.Errorf("filepath of tokens '%v' could not be read: %v", filepath, err)
+	}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return t, fmt.Errorf("could not unmarshal tokens at filepath '%v': %v", filepath, err)
+	}
+	return t, nil
+}
+
+// printHealth prints when the tokens expire and, unless the access token
+// has expired, how the Fitbit API introspects it. The tokens are not
+// refreshed, so that checking their health does not change them.
+func printHealth(t bitfit.Tokens) {
+	now := time.Now()
+	switch {
+	case t.Access == "":
+		fmt.Println("access token:\tmissing")
+	case t.Expiration.Before(now):
+		fmt.Printf("access token:\texpired %v ago (%v)\n", now.Sub(t.Expiration).Round(time.Second), t.Expiration)
+	default:
+		fmt.Printf("access token:\texpires in %v (%v)\n", t.Expiration.Sub(now).Round(time.Second), t.Expiration)
+	}
+	if t.Refresh == "" {
+		fmt.Println("refresh token:\tmissing")
+	} else {
+		fmt.Println("refresh token:\tpresent")
+	}
+	if t.Access == "" || t.Expiration.Before(now) {
+		return
+	}
+	i, err := bitfit.IntrospectToken(t.Access, t.Access)
+	if err != nil {
+		fmt.Printf("introspection:\tfailed: %v\n", err)
+		return
+	}
+	fmt.Printf("active:\t\t%v\n", i.Active)
+	if !i.Active {
+		return
+	}
+	fmt.Printf("user ID:\t%v\n", i.UserID)
+	fmt.Printf("scopes:\t\t%v\n", strings.Join(i.Scopes, " "))
+	fmt.Printf("expiration:\t%v\n", i.Expiration)
+}
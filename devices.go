@@ -0,0 +1,210 @@
+package bitfit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Device struct {
+	ID           string
+	Type         string
+	Version      string
+	MAC          string
+	Battery      string
+	BatteryLevel uint
+	LastSync     time.Time
+	Features     []string
+}
+
+func (d *Device) UnmarshalJSON(data []byte) error {
+	j := struct {
+		ID            string
+		Type          string
+		DeviceVersion string
+		Mac           string
+		Battery       string
+		BatteryLevel  uint
+		LastSyncTime  string
+		Features      []string
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	d.ID = j.ID
+	d.Type = j.Type
+	d.Version = j.DeviceVersion
+	d.MAC = j.Mac
+	d.Battery = j.Battery
+	d.BatteryLevel = j.BatteryLevel
+	d.Features = j.Features
+	if j.LastSyncTime == "" {
+		return nil
+	}
+	var err error
+	d.LastSync, err = parseInNYC(j.LastSyncTime)
+	return err
+}
+
+func (d Device) IsTracker() bool {
+	return d.Type == "TRACKER"
+}
+
+func (c *Client) FetchDevices() ([]Device, error) {
+	a := make([]Device, 0)
+	if err := c.fetchInto(apiURL("1/user/-/devices.json"), &a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// HasSyncedSince reports whether any of the user's trackers has synced
+// after t, e.g. the end of a night, such that the sleep log of that night
+// is complete and worth fetching.
+func (c *Client) HasSyncedSince(t time.Time) (bool, error) {
+	devices, err := c.FetchDevices()
+	if err != nil {
+		return false, err
+	}
+	for _, d := range devices {
+		if d.IsTracker() && d.LastSync.After(t) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type Alarm struct {
+	ID uint64
+	// Time is formatted as the hour and minute with a UTC offset,
+	// e.g. "07:15-08:00", as expected by the Fitbit API.
+	Time           string
+	Label          string
+	Enabled        bool
+	Recurring      bool
+	WeekDays       []time.Weekday
+	SnoozeLength   time.Duration
+	SnoozeCount    uint
+	Vibe           string
+	SyncedToDevice bool
+	Deleted        bool
+}
+
+func (a *Alarm) UnmarshalJSON(data []byte) error {
+	j := struct {
+		AlarmID        uint64
+		Time           string
+		Label          string
+		Enabled        bool
+		Recurring      bool
+		WeekDays       []string
+		SnoozeLength   uint
+		SnoozeCount    uint
+		Vibe           string
+		SyncedToDevice bool
+		Deleted        bool
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	a.ID = j.AlarmID
+	a.Time = j.Time
+	a.Label = j.Label
+	a.Enabled = j.Enabled
+	a.Recurring = j.Recurring
+	a.SnoozeCount = j.SnoozeCount
+	a.Vibe = j.Vibe
+	a.SyncedToDevice = j.SyncedToDevice
+	a.Deleted = j.Deleted
+	var err error
+	if a.SnoozeLength, err = parseMin(j.SnoozeLength); err != nil {
+		return err
+	}
+	a.WeekDays = make([]time.Weekday, 0, len(j.WeekDays))
+	for _, s := range j.WeekDays {
+		d, err := parseWeekday(s)
+		if err != nil {
+			return err
+		}
+		a.WeekDays = append(a.WeekDays, d)
+	}
+	return nil
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(d.String(), s) {
+			return d, nil
+		}
+	}
+	return time.Sunday, fmt.Errorf("'%v' is not a day of the week", s)
+}
+
+func (a Alarm) form() url.Values {
+	days := make([]string, len(a.WeekDays))
+	for i, d := range a.WeekDays {
+		days[i] = strings.ToUpper(d.String())
+	}
+	form := url.Values{}
+	form.Set("time", a.Time)
+	form.Set("enabled", strconv.FormatBool(a.Enabled))
+	form.Set("recurring", strconv.FormatBool(a.Recurring))
+	form.Set("weekDays", strings.Join(days, ","))
+	if a.Label != "" {
+		form.Set("label", a.Label)
+	}
+	if a.SnoozeLength != 0 {
+		form.Set("snoozeLength", strconv.Itoa(int(a.SnoozeLength.Minutes())))
+	}
+	if a.SnoozeCount != 0 {
+		form.Set("snoozeCount", strconv.Itoa(int(a.SnoozeCount)))
+	}
+	if a.Vibe != "" {
+		form.Set("vibe", a.Vibe)
+	}
+	return form
+}
+
+func (c *Client) FetchAlarms(trackerID string) ([]Alarm, error) {
+	s := apiURL("1/user/-/devices/tracker/%v/alarms.json")
+	j := struct {
+		TrackerAlarms []Alarm
+	}{}
+	if err := c.fetchInto(fmt.Sprintf(s, url.PathEscape(trackerID)), &j); err != nil {
+		return nil, err
+	}
+	return j.TrackerAlarms, nil
+}
+
+// AddAlarm adds an alarm to a tracker, ignoring any ID set on the alarm, and
+// returns the alarm as it was saved.
+func (c *Client) AddAlarm(trackerID string, a Alarm) (Alarm, error) {
+	s := apiURL("1/user/-/devices/tracker/%v/alarms.json")
+	return c.saveAlarm(fmt.Sprintf(s, url.PathEscape(trackerID)), a)
+}
+
+// UpdateAlarm replaces the settings of the tracker's alarm with the same ID
+// as the one provided and returns the alarm as it was saved.
+func (c *Client) UpdateAlarm(trackerID string, a Alarm) (Alarm, error) {
+	s := apiURL("1/user/-/devices/tracker/%v/alarms/%d.json")
+	return c.saveAlarm(fmt.Sprintf(s, url.PathEscape(trackerID), a.ID), a)
+}
+
+func (c *Client) saveAlarm(rawURL string, a Alarm) (Alarm, error) {
+	j := struct {
+		TrackerAlarm Alarm
+	}{}
+	if err := c.sendInto("POST", rawURL, a.form(), &j); err != nil {
+		return Alarm{}, err
+	}
+	return j.TrackerAlarm, nil
+}
+
+func (c *Client) DeleteAlarm(trackerID string, alarmID uint64) error {
+	s := apiURL("1/user/-/devices/tracker/%v/alarms/%d.json")
+	_, err := c.send("DELETE", fmt.Sprintf(s, url.PathEscape(trackerID), alarmID), nil)
+	return err
+}
@@ -0,0 +1,50 @@
+package bitfit
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHasSyncedSince(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if e, a := "/1/user/-/devices.json", r.URL.Path; e != a {
+			t.Errorf("expected request to '%v' but received '%v'", e, a)
+		}
+		if e, a := "Bearer foo", r.Header.Get("Authorization"); e != a {
+			t.Errorf("expected authorization '%v' but received '%v'", e, a)
+		}
+		fmt.Fprint(w, `[
+			{"battery":"High","batteryLevel":95,"deviceVersion":"Aria","id":"1","lastSyncTime":"2019-09-17T12:00:00.000","type":"SCALE"},
+			{"battery":"Medium","batteryLevel":40,"deviceVersion":"Charge 3","id":"2","lastSyncTime":"2019-09-16T08:00:00.000","type":"TRACKER"}
+		]`)
+	})
+	d, err := c.FetchDevices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	errFmt := "expected %v but received %v"
+	if e, a := 2, len(d); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := uint(40), d[1].BatteryLevel; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	for s, e := range map[string]bool{
+		"2019-09-16T07:00:00.000": true,
+		"2019-09-16T09:09:30.000": false,
+	} {
+		tt, err := time.ParseInLocation("2006-01-02T15:04:05.000", s, newYork)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := c.HasSyncedSince(tt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e != a {
+			t.Fatalf("expected synced since %v to be %v but was %v", s, e, a)
+		}
+	}
+}
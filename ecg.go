@@ -0,0 +1,262 @@
+package bitfit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ListQuery bounds the entries of a list resource by a date either before
+// or after which to list them, as the Fitbit API requires one but not both.
+// Entries before a date are listed in descending order, and those after a
+// date in ascending order. The limit is the number of entries per page, of
+// at most ten.
+type ListQuery struct {
+	BeforeDate time.Time
+	AfterDate  time.Time
+	Limit      uint
+	Offset     uint
+}
+
+func (q ListQuery) encode() (string, error) {
+	v := url.Values{}
+	switch {
+	case q.BeforeDate.IsZero() == q.AfterDate.IsZero():
+		return "", errors.New("exactly one of a before date or an after date is required to list entries")
+	case !q.BeforeDate.IsZero():
+		v.Set("beforeDate", q.BeforeDate.Format(dateFmt))
+		v.Set("sort", "desc")
+	default:
+		v.Set("afterDate", q.AfterDate.Format(dateFmt))
+		v.Set("sort", "asc")
+	}
+	switch {
+	case q.Limit == 0:
+		v.Set("limit", "10")
+	case q.Limit > 10:
+		return "", fmt.Errorf("a limit of %d exceeds the maximum of 10 entries per page", q.Limit)
+	default:
+		v.Set("limit", strconv.FormatUint(uint64(q.Limit), 10))
+	}
+	v.Set("offset", strconv.FormatUint(uint64(q.Offset), 10))
+	return v.Encode(), nil
+}
+
+// pagination is the pagination of a list resource.
+type pagination struct {
+	Pagination struct {
+		Next string
+	}
+}
+
+// ECGReading is an electrocardiogram recorded by a device, where each of
+// the waveform samples is a voltage scaled by the scaling factor.
+type ECGReading struct {
+	Start                time.Time
+	AverageHeartRate     uint
+	ResultClassification string
+	WaveformSamples      []int
+	SamplingFrequency    float64
+	ScalingFactor        float64
+	LeadNumber           uint
+	FeatureVersion       string
+	DeviceName           string
+	FirmwareVersion      string
+}
+
+func (e *ECGReading) UnmarshalJSON(data []byte) error {
+	j := struct {
+		StartTime            string
+		AverageHeartRate     uint
+		ResultClassification string
+		WaveformSamples      []int
+		SamplingFrequencyHz  json.Number
+		ScalingFactor        float64
+		LeadNumber           uint
+		FeatureVersion       string
+		DeviceName           string
+		FirmwareVersion      string
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	var err error
+	if e.Start, err = parseInNYC(j.StartTime); err != nil {
+		return err
+	}
+	if j.SamplingFrequencyHz != "" {
+		if e.SamplingFrequency, err = j.SamplingFrequencyHz.Float64(); err != nil {
+			s := "could not parse sampling frequency '%v': %v"
+			return fmt.Errorf(s, j.SamplingFrequencyHz, err)
+		}
+	}
+	e.AverageHeartRate = j.AverageHeartRate
+	e.ResultClassification = j.ResultClassification
+	e.WaveformSamples = j.WaveformSamples
+	e.ScalingFactor = j.ScalingFactor
+	e.LeadNumber = j.LeadNumber
+	e.FeatureVersion = j.FeatureVersion
+	e.DeviceName = j.DeviceName
+	e.FirmwareVersion = j.FirmwareVersion
+	return nil
+}
+
+// Millivolts returns the waveform samples scaled to millivolts.
+func (e ECGReading) Millivolts() []float64 {
+	a := make([]float64, len(e.WaveformSamples))
+	if e.ScalingFactor == 0 {
+		return a
+	}
+	for i, s := range e.WaveformSamples {
+		a[i] = float64(s) / e.ScalingFactor
+	}
+	return a
+}
+
+// Duration is the length of time over which the waveform was sampled.
+func (e ECGReading) Duration() time.Duration {
+	if e.SamplingFrequency == 0 {
+		return 0
+	}
+	return time.Duration(float64(len(e.WaveformSamples)) / e.SamplingFrequency * float64(time.Second))
+}
+
+// FetchECGReadings fetches the readings selected by the query, following
+// each page of the list after that of the query's offset.
+func (c *Client) FetchECGReadings(q ListQuery) ([]ECGReading, error) {
+	s, err := q.encode()
+	if err != nil {
+		return nil, err
+	}
+	a := make([]ECGReading, 0)
+	err = c.fetchPages(apiURL("1/user/-/ecg/list.json?"+s), func(b []byte) (string, error) {
+		j := struct {
+			pagination
+			ECGReadings []ECGReading
+		}{}
+		if err := json.Unmarshal(b, &j); err != nil {
+			return "", err
+		}
+		a = append(a, j.ECGReadings...)
+		return j.Pagination.Next, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+type Tachogram struct {
+	Start time.Time
+	// Data is left undecoded, as its format is not documented by Fitbit.
+	Data json.RawMessage
+}
+
+func (t *Tachogram) UnmarshalJSON(data []byte) error {
+	j := struct {
+		StartTime string
+		Data      json.RawMessage
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	t.Data = j.Data
+	var err error
+	t.Start, err = parseInNYC(j.StartTime)
+	return err
+}
+
+// IRNAlert is an irregular rhythm notification, sent when a device detects
+// signs of atrial fibrillation across the intervals of its tachograms.
+type IRNAlert struct {
+	AlertTime      time.Time
+	DetectedTime   time.Time
+	ServiceVersion string
+	Tachograms     []Tachogram
+}
+
+func (i *IRNAlert) UnmarshalJSON(data []byte) error {
+	j := struct {
+		AlertTime      string
+		DetectedTime   string
+		ServiceVersion string
+		Tachograms     []Tachogram
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	var err error
+	if i.AlertTime, err = parseInNYC(j.AlertTime); err != nil {
+		return err
+	}
+	if j.DetectedTime != "" {
+		if i.DetectedTime, err = parseInNYC(j.DetectedTime); err != nil {
+			return err
+		}
+	}
+	i.ServiceVersion = j.ServiceVersion
+	i.Tachograms = j.Tachograms
+	return nil
+}
+
+// FetchIRNAlerts fetches the alerts selected by the query, following each
+// page of the list after that of the query's offset.
+func (c *Client) FetchIRNAlerts(q ListQuery) ([]IRNAlert, error) {
+	s, err := q.encode()
+	if err != nil {
+		return nil, err
+	}
+	a := make([]IRNAlert, 0)
+	err = c.fetchPages(apiURL("1/user/-/irn/alerts/list.json?"+s), func(b []byte) (string, error) {
+		j := struct {
+			pagination
+			Alerts []IRNAlert
+		}{}
+		if err := json.Unmarshal(b, &j); err != nil {
+			return "", err
+		}
+		a = append(a, j.Alerts...)
+		return j.Pagination.Next, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// IRNProfile is the user's enrollment in irregular rhythm notifications.
+type IRNProfile struct {
+	Onboarded   bool
+	Enrolled    bool
+	LastUpdated time.Time
+}
+
+func (i *IRNProfile) UnmarshalJSON(data []byte) error {
+	j := struct {
+		Onboarded   bool
+		Enrolled    bool
+		LastUpdated string
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	i.Onboarded = j.Onboarded
+	i.Enrolled = j.Enrolled
+	if j.LastUpdated == "" {
+		return nil
+	}
+	var err error
+	i.LastUpdated, err = parseInNYC(j.LastUpdated)
+	return err
+}
+
+func (c *Client) FetchIRNProfile() (IRNProfile, error) {
+	i := IRNProfile{}
+	if err := c.fetchInto(apiURL("1/user/-/irn/profile.json"), &i); err != nil {
+		return i, err
+	}
+	return i, nil
+}
@@ -0,0 +1,67 @@
+package bitfit
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestFetchingPaginatedECGReadings(t *testing.T) {
+	var srvURL string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if e, a := "2019-09-16", q.Get("afterDate"); e != a {
+			t.Errorf("expected after date '%v' but received '%v'", e, a)
+		}
+		if e, a := "asc", q.Get("sort"); e != a {
+			t.Errorf("expected sort '%v' but received '%v'", e, a)
+		}
+		switch q.Get("offset") {
+		case "0":
+			fmt.Fprintf(w, `{
+				"ecgReadings": [{"startTime": "2019-09-16T17:12:30.222", "averageHeartRate": 70, "resultClassification": "Normal Sinus Rhythm", "waveformSamples": [10922, -5461, 0, 21844], "samplingFrequencyHz": "250", "scalingFactor": 10922, "numberOfWaveformSamples": 4, "leadNumber": 1}],
+				"pagination": {"afterDate": "2019-09-16", "limit": 1, "next": "%v/1/user/-/ecg/list.json?offset=1&limit=1&sort=asc&afterDate=2019-09-16", "offset": 0, "previous": "", "sort": "asc"}
+			}`, srvURL)
+		case "1":
+			fmt.Fprint(w, `{
+				"ecgReadings": [{"startTime": "2019-09-18T08:00:00.000", "averageHeartRate": 64, "resultClassification": "Inconclusive", "waveformSamples": [], "samplingFrequencyHz": "250", "scalingFactor": 10922}],
+				"pagination": {"afterDate": "2019-09-16", "limit": 1, "next": "", "offset": 1, "previous": "", "sort": "asc"}
+			}`)
+		default:
+			t.Errorf("unexpected request for '%v'", r.URL)
+		}
+	})
+	srvURL = BaseURL
+	if _, err := c.FetchECGReadings(ListQuery{}); err == nil {
+		t.Fatal("expected an error listing readings without a before or after date")
+	}
+	d, err := time.ParseInLocation("2006-01-02", "2019-09-16", newYork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readings, err := c.FetchECGReadings(ListQuery{AfterDate: d, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	errFmt := "expected %v but received %v"
+	if e, a := 2, len(readings); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := 250.0, readings[0].SamplingFrequency; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	mv := readings[0].Millivolts()
+	if e, a := 4, len(mv); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if mv[0] != 1 || mv[1] != -0.5 || mv[3] != 2 {
+		t.Fatalf("expected samples to be scaled to millivolts but received %v", mv)
+	}
+	if e, a := 16*time.Millisecond, readings[0].Duration(); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := "Inconclusive", readings[1].ResultClassification; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+}
@@ -0,0 +1,185 @@
+package bitfit
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ActiveZoneMinutes are the minutes spent in each heart rate zone, where
+// minutes in the cardio and peak zones count double towards the total.
+type ActiveZoneMinutes struct {
+	Total   uint
+	FatBurn uint
+	Cardio  uint
+	Peak    uint
+}
+
+func (a *ActiveZoneMinutes) UnmarshalJSON(data []byte) error {
+	j := struct {
+		ActiveZoneMinutes        uint
+		FatBurnActiveZoneMinutes uint
+		CardioActiveZoneMinutes  uint
+		PeakActiveZoneMinutes    uint
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	a.Total = j.ActiveZoneMinutes
+	a.FatBurn = j.FatBurnActiveZoneMinutes
+	a.Cardio = j.CardioActiveZoneMinutes
+	a.Peak = j.PeakActiveZoneMinutes
+	return nil
+}
+
+type DailyActiveZoneMinutes struct {
+	Date time.Time
+	ActiveZoneMinutes
+}
+
+func (d *DailyActiveZoneMinutes) UnmarshalJSON(data []byte) error {
+	j := struct {
+		DateTime string
+		Value    ActiveZoneMinutes
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	var err error
+	if d.Date, err = parseDateInNYC(j.DateTime); err != nil {
+		return err
+	}
+	d.ActiveZoneMinutes = j.Value
+	return nil
+}
+
+type IntradayActiveZoneMinutes struct {
+	Start time.Time
+	ActiveZoneMinutes
+}
+
+func (i *IntradayActiveZoneMinutes) UnmarshalJSON(data []byte) error {
+	j := struct {
+		Minute string
+		Value  ActiveZoneMinutes
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	var err error
+	if i.Start, err = time.ParseInLocation(intradayTimeFmt, j.Minute, newYork); err != nil {
+		return err
+	}
+	i.ActiveZoneMinutes = j.Value
+	return nil
+}
+
+var intradayTimeFmt = "2006-01-02T15:04:05"
+
+// DetailLevel is the granularity of an intraday time series.
+type DetailLevel string
+
+const (
+	OneMinute      DetailLevel = "1min"
+	FiveMinutes    DetailLevel = "5min"
+	FifteenMinutes DetailLevel = "15min"
+)
+
+func (c *Client) FetchActiveZoneMinutes(from, to time.Time) ([]DailyActiveZoneMinutes, error) {
+	s := apiURL("1/user/-/activities/active-zone-minutes/date/%v/%v.json")
+	j := make(map[string][]DailyActiveZoneMinutes)
+	if err := c.fetchInto(fmt.Sprintf(s, from.Format(dateFmt), to.Format(dateFmt)), &j); err != nil {
+		return nil, err
+	}
+	return j["activities-active-zone-minutes"], nil
+}
+
+func (c *Client) FetchIntradayActiveZoneMinutes(on time.Time, d DetailLevel) ([]IntradayActiveZoneMinutes, error) {
+	s := apiURL("1/user/-/activities/active-zone-minutes/date/%v/1d/%v.json")
+	j := make(map[string][]struct {
+		Minutes []IntradayActiveZoneMinutes
+	})
+	if err := c.fetchInto(fmt.Sprintf(s, on.Format(dateFmt), d), &j); err != nil {
+		return nil, err
+	}
+	a := make([]IntradayActiveZoneMinutes, 0)
+	for _, day := range j["activities-active-zone-minutes-intraday"] {
+		a = append(a, day.Minutes...)
+	}
+	return a, nil
+}
+
+// VO2Max is an estimate of the maximum rate of oxygen consumption, in
+// millilitres per kilogram per minute. Fitbit reports it as a range unless
+// it was measured during a run with GPS, in which case Low equals High.
+type VO2Max struct {
+	Low  float64
+	High float64
+}
+
+// ParseVO2Max parses a VO2 Max value as it is formatted by the Fitbit API,
+// which is either a range (e.g. "44-48") or a single number (e.g. "46.5").
+func ParseVO2Max(s string) (VO2Max, error) {
+	v := VO2Max{}
+	parts := strings.SplitN(strings.TrimSpace(s), "-", 2)
+	var err error
+	if v.Low, err = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64); err != nil {
+		return v, fmt.Errorf("could not parse VO2 Max '%v': %v", s, err)
+	}
+	v.High = v.Low
+	if len(parts) == 1 {
+		return v, nil
+	}
+	if v.High, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64); err != nil {
+		return v, fmt.Errorf("could not parse VO2 Max '%v': %v", s, err)
+	}
+	if v.High < v.Low {
+		return v, fmt.Errorf("VO2 Max range '%v' is in descending order", s)
+	}
+	return v, nil
+}
+
+type CardioFitnessScore struct {
+	Date time.Time
+	VO2Max
+}
+
+func (c *CardioFitnessScore) UnmarshalJSON(data []byte) error {
+	j := struct {
+		DateTime string
+		Value    struct {
+			VO2Max string
+		}
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	var err error
+	if c.Date, err = parseDateInNYC(j.DateTime); err != nil {
+		return err
+	}
+	c.VO2Max, err = ParseVO2Max(j.Value.VO2Max)
+	return err
+}
+
+func (c *Client) FetchCardioFitnessScore(on time.Time) ([]CardioFitnessScore, error) {
+	s := apiURL("1/user/-/cardioscore/date/%v.json")
+	return c.fetchCardioFitnessScore(fmt.Sprintf(s, on.Format(dateFmt)))
+}
+
+func (c *Client) FetchCardioFitnessScoreInterval(from, to time.Time) ([]CardioFitnessScore, error) {
+	s := apiURL("1/user/-/cardioscore/date/%v/%v.json")
+	return c.fetchCardioFitnessScore(fmt.Sprintf(s, from.Format(dateFmt), to.Format(dateFmt)))
+}
+
+func (c *Client) fetchCardioFitnessScore(url string) ([]CardioFitnessScore, error) {
+	j := struct {
+		CardioScore []CardioFitnessScore
+	}{}
+	if err := c.fetchInto(url, &j); err != nil {
+		return nil, err
+	}
+	return j.CardioScore, nil
+}
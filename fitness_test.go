@@ -0,0 +1,46 @@
+package bitfit
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParsingVO2Max(t *testing.T) {
+	errFmt := "expected %v but received %v"
+	for s, e := range map[string]VO2Max{
+		"44-48": {44, 48},
+		"46.5":  {46.5, 46.5},
+		" 39 ":  {39, 39},
+	} {
+		a, err := ParseVO2Max(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e != a {
+			t.Fatalf(errFmt, e, a)
+		}
+	}
+	for _, s := range []string{"", "44-", "48-44", "high"} {
+		if v, err := ParseVO2Max(s); err == nil {
+			t.Fatalf("expected error parsing '%v' but received %+v", s, v)
+		}
+	}
+}
+
+func TestUnmarshallingIntradayActiveZoneMinutes(t *testing.T) {
+	b := []byte(`{"minute":"2020-01-01T06:15:00","value":{"activeZoneMinutes":2,"cardioActiveZoneMinutes":1}}`)
+	i := new(IntradayActiveZoneMinutes)
+	if err := json.Unmarshal(b, i); err != nil {
+		t.Fatal(err)
+	}
+	errFmt := "expected %v but received %v"
+	if e, a := "2020-01-01 06:15:00 -0500 EST", i.Start.String(); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := uint(2), i.Total; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := uint(1), i.Cardio; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+}
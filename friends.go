@@ -0,0 +1,168 @@
+package bitfit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+type Friend struct {
+	ID       string
+	Name     string
+	Avatar   string
+	IsChild  bool
+	IsFriend bool
+}
+
+func (f *Friend) UnmarshalJSON(data []byte) error {
+	j := struct {
+		ID         string
+		Attributes struct {
+			Name   string
+			Avatar string
+			Child  bool
+			Friend bool
+		}
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	f.ID = j.ID
+	f.Name = j.Attributes.Name
+	f.Avatar = j.Attributes.Avatar
+	f.IsChild = j.Attributes.Child
+	f.IsFriend = j.Attributes.Friend
+	return nil
+}
+
+// LeaderboardEntry is a user's rank by steps over the past seven days,
+// where the rank is zero if the user has been inactive.
+type LeaderboardEntry struct {
+	UserID string
+	Rank   uint
+	Steps  uint
+	Friend *Friend
+}
+
+func (l *LeaderboardEntry) UnmarshalJSON(data []byte) error {
+	j := struct {
+		// The names of the attributes are hyphenated, so must be tagged.
+		Attributes struct {
+			Rank    uint `json:"step-rank"`
+			Summary uint `json:"step-summary"`
+		}
+		Relationships struct {
+			User struct {
+				Data struct {
+					ID string
+				}
+			}
+		}
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	l.UserID = j.Relationships.User.Data.ID
+	l.Rank = j.Attributes.Rank
+	l.Steps = j.Attributes.Summary
+	return nil
+}
+
+// fetchPages fetches each page of a paginated resource, starting at rawURL,
+// and calls each with the response body of every page, which returns the
+// URL of the next page or an empty string if it was the last.
+func (c *Client) fetchPages(rawURL string, each func(page []byte) (next string, err error)) error {
+	for seen := map[string]bool{}; rawURL != "" && !seen[rawURL]; {
+		seen[rawURL] = true
+		b, err := c.fetch(rawURL)
+		if err != nil {
+			return err
+		}
+		next, err := each(b)
+		if err != nil {
+			return fmt.Errorf("could not unmarshal page: %v", err)
+		}
+		if next == "" {
+			break
+		}
+		if rawURL, err = nextPageURL(next); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nextPageURL resolves the link to a next page against the BaseURL, and
+// returns an error if it is not beneath it, so that the client's tokens are
+// never sent with a request to another origin.
+func nextPageURL(next string) (string, error) {
+	base, err := url.Parse(BaseURL)
+	if err != nil {
+		return "", err
+	}
+	u, err := base.Parse(next)
+	if err != nil {
+		return "", fmt.Errorf("could not parse URL of next page '%v': %v", next, err)
+	}
+	if u.Scheme != base.Scheme || u.Host != base.Host || !strings.HasPrefix(u.Path, strings.TrimSuffix(base.Path, "/")+"/") {
+		return "", fmt.Errorf("URL of next page '%v' is not of the Fitbit API at '%v'", next, BaseURL)
+	}
+	return u.String(), nil
+}
+
+// jsonAPILinks are the links of a JSON:API document, as served by the
+// newer (1.1) endpoints.
+type jsonAPILinks struct {
+	Links struct {
+		Next string
+	}
+}
+
+func (c *Client) FetchFriends() ([]Friend, error) {
+	a := make([]Friend, 0)
+	err := c.fetchPages(apiURL("1.1/user/-/friends.json"), func(b []byte) (string, error) {
+		j := struct {
+			jsonAPILinks
+			Data []Friend
+		}{}
+		if err := json.Unmarshal(b, &j); err != nil {
+			return "", err
+		}
+		a = append(a, j.Data...)
+		return j.Links.Next, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// FetchLeaderboard fetches the user's and their friends' ranks by steps,
+// in order of rank, with the Friend of each entry set when it is included.
+func (c *Client) FetchLeaderboard() ([]LeaderboardEntry, error) {
+	a := make([]LeaderboardEntry, 0)
+	friends := make(map[string]*Friend)
+	err := c.fetchPages(apiURL("1.1/user/-/leaderboard/friends.json"), func(b []byte) (string, error) {
+		j := struct {
+			jsonAPILinks
+			Data     []LeaderboardEntry
+			Included []Friend
+		}{}
+		if err := json.Unmarshal(b, &j); err != nil {
+			return "", err
+		}
+		a = append(a, j.Data...)
+		for i := range j.Included {
+			friends[j.Included[i].ID] = &j.Included[i]
+		}
+		return j.Links.Next, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range a {
+		a[i].Friend = friends[a[i].UserID]
+	}
+	return a, nil
+}
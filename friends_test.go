@@ -0,0 +1,71 @@
+package bitfit
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFetchingPaginatedLeaderboard(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("page") {
+		case "":
+			fmt.Fprint(w, `{
+				"data": [{"type": "ranked-user", "id": "A", "attributes": {"step-rank": 1, "step-summary": 81234}, "relationships": {"user": {"data": {"type": "person", "id": "A"}}}}],
+				"included": [{"type": "person", "id": "A", "attributes": {"name": "Foo", "friend": true, "child": false}}],
+				"links": {"next": "/1.1/user/-/leaderboard/friends.json?page=2"}
+			}`)
+		case "2":
+			fmt.Fprint(w, `{
+				"data": [{"type": "inactive-user", "id": "B", "relationships": {"user": {"data": {"type": "person", "id": "B"}}}}],
+				"included": [{"type": "person", "id": "B", "attributes": {"name": "Bar", "friend": true, "child": true}}]
+			}`)
+		default:
+			t.Errorf("unexpected request for '%v'", r.URL)
+		}
+	})
+	l, err := c.FetchLeaderboard()
+	if err != nil {
+		t.Fatal(err)
+	}
+	errFmt := "expected %v but received %v"
+	if e, a := 2, len(l); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := uint(81234), l[0].Steps; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := "Foo", l[0].Friend.Name; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := uint(0), l[1].Rank; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := true, l[1].Friend.IsChild; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+}
+
+func TestNotFollowingNextPagesOfOtherOrigins(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("expected no request of another origin but received one with '%v'", r.Header.Get("Authorization"))
+	}))
+	defer other.Close()
+	var base string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("page") {
+		case "":
+			fmt.Fprintf(w, `{"data": [], "links": {"next": "%v/1.1/user/-/friends.json?page=2"}}`, base)
+		case "2":
+			fmt.Fprintf(w, `{"data": [], "links": {"next": "%v/1.1/user/-/friends.json?page=3"}}`, other.URL)
+		default:
+			t.Errorf("unexpected request for '%v'", r.URL)
+		}
+	})
+	base = BaseURL
+	if _, err := c.FetchFriends(); err == nil || !strings.Contains(err.Error(), "is not of the Fitbit API") {
+		t.Fatalf("expected an error of the next page of another origin but received %v", err)
+	}
+}
@@ -0,0 +1,259 @@
+package bitfit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// TokenStore loads and saves the tokens of many users by their user ID.
+type TokenStore interface {
+	Load(userID string) (Tokens, error)
+	Save(userID string, t Tokens) error
+	UserIDs() ([]string, error)
+}
+
+// DirTokenStore stores the tokens of each user in a file of a directory,
+// named by the user's ID (e.g. BAZ.json), as serialized by a Client.
+type DirTokenStore string
+
+func (d DirTokenStore) filepath(userID string) (string, error) {
+	if userID == "" || userID != filepath.Base(userID) || strings.HasPrefix(userID, ".") {
+		return "", fmt.Errorf("'%v' is not a valid user ID", userID)
+	}
+	return filepath.Join(string(d), userID+".json"), nil
+}
+
+func (d DirTokenStore) Load(userID string) (Tokens, error) {
+	p, err := d.filepath(userID)
+	if err != nil {
+		return Tokens{}, err
+	}
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		s := "filepath of tokens '%v' could not be read: %v"
+		return Tokens{}, fmt.Errorf(s, p, err)
+	}
+	t := Tokens{}
+	if err := json.Unmarshal(b, &t); err != nil {
+		s := "could not unmarshal tokens at filepath '%v': %v"
+		return t, fmt.Errorf(s, p, err)
+	}
+	if t.UserID == "" {
+		t.UserID = userID
+	}
+	return t, nil
+}
+
+func (d DirTokenStore) Save(userID string, t Tokens) error {
+	p, err := d.filepath(userID)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("could not serialize tokens: %v", err)
+	}
+	if b, err = format(b); err != nil {
+		return fmt.Errorf("could not format serialized tokens: %v", err)
+	}
+	// Tokens are secrets, so are not readable by others.
+	if err := ioutil.WriteFile(p, b, 0600); err != nil {
+		s := "could not save tokens to file '%v': %v"
+		return fmt.Errorf(s, p, err)
+	}
+	return nil
+}
+
+func (d DirTokenStore) UserIDs() ([]string, error) {
+	a, err := filepath.Glob(filepath.Join(string(d), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(a))
+	for _, p := range a {
+		ids = append(ids, strings.TrimSuffix(filepath.Base(p), ".json"))
+	}
+	return ids, nil
+}
+
+// NewStoreClient is a constructor for a Client of a user whose tokens are
+// loaded from and saved to a TokenStore rather than a tokens file.
+func NewStoreClient(id, secret, userID string, store TokenStore) *Client {
+	c := NewClient(id, secret, "")
+	c.TokensLoader = func() (Tokens, error) {
+		return store.Load(userID)
+	}
+	c.TokensSaver = func(t Tokens) error {
+		return store.Save(userID, t)
+	}
+	return c
+}
+
+// Manager holds an initialized Client for each of many users, keyed by
+// their Fitbit user ID, each of which refreshes its own tokens and keeps
+// account of its own rate limit.
+type Manager struct {
+	id     string
+	secret string
+	store  TokenStore
+	// Refreshed, if set, is the Refreshed func of each user's Client.
+	Refreshed func(userID string, t Tokens, err error)
+	mu        sync.Mutex
+	clients   map[string]*Client
+	// inits serialize the initialization of each user's client, since it
+	// may refresh the user's tokens, which can only be refreshed once.
+	inits map[string]*sync.Mutex
+}
+
+func NewManager(id, secret string, store TokenStore) *Manager {
+	return &Manager{
+		id:      id,
+		secret:  secret,
+		store:   store,
+		clients: make(map[string]*Client),
+		inits:   make(map[string]*sync.Mutex),
+	}
+}
+
+// Client returns the Client of the user, initializing it with the user's
+// tokens from the store on first use. Clients are initialized without
+// holding up the use of other users' clients, since initialization may
+// refresh their tokens, but only once at a time for each user.
+func (m *Manager) Client(userID string) (*Client, error) {
+	m.mu.Lock()
+	c, ok := m.clients[userID]
+	m.mu.Unlock()
+	if ok {
+		return c, nil
+	}
+	l := m.initLock(userID)
+	l.Lock()
+	defer l.Unlock()
+	m.mu.Lock()
+	c, ok = m.clients[userID]
+	m.mu.Unlock()
+	if ok {
+		return c, nil
+	}
+	c = NewStoreClient(m.id, m.secret, userID, m.store)
+	if m.Refreshed != nil {
+		c.Refreshed = func(t Tokens, err error) {
+			m.Refreshed(userID, t, err)
+		}
+	}
+	if err := c.Init(); err != nil {
+		return nil, fmt.Errorf("could not initialize client of user '%v': %v", userID, err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clients[userID] = c
+	return c, nil
+}
+
+// initLock returns the lock of the initialization of the user's client.
+func (m *Manager) initLock(userID string) *sync.Mutex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.inits[userID]
+	if !ok {
+		l = &sync.Mutex{}
+		m.inits[userID] = l
+	}
+	return l
+}
+
+// Add saves the tokens of a newly authorized user to the store, and swaps
+// them in for those of the Client the user already has, if any, so that
+// those using it make requests with the tokens from then on.
+func (m *Manager) Add(t Tokens) (*Client, error) {
+	if t.UserID == "" {
+		return nil, errors.New("tokens must have a user ID to be added")
+	}
+	// The tokens are saved while no client of the user is initialized, so
+	// that none is initialized with the tokens they replace.
+	l := m.initLock(t.UserID)
+	l.Lock()
+	m.mu.Lock()
+	c, ok := m.clients[t.UserID]
+	m.mu.Unlock()
+	var err error
+	if ok {
+		err = c.SetTokens(t)
+	} else {
+		err = m.store.Save(t.UserID, t)
+	}
+	l.Unlock()
+	switch {
+	case err != nil:
+		return nil, err
+	case ok:
+		return c, nil
+	}
+	return m.Client(t.UserID)
+}
+
+// Has reports whether the store has tokens of the user.
+func (m *Manager) Has(userID string) (bool, error) {
+	ids, err := m.store.UserIDs()
+	if err != nil {
+		return false, fmt.Errorf("could not list users of token store: %v", err)
+	}
+	for _, id := range ids {
+		if id == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Remove forgets the Client of the user, without deleting their tokens.
+func (m *Manager) Remove(userID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.clients, userID)
+}
+
+// LoadAll initializes the Client of every user in the store, returning an
+// error for the first that could not be, after trying each of them.
+func (m *Manager) LoadAll() error {
+	ids, err := m.store.UserIDs()
+	if err != nil {
+		return fmt.Errorf("could not list users of token store: %v", err)
+	}
+	var first error
+	for _, id := range ids {
+		if _, err := m.Client(id); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// UserIDs returns the IDs of users with an initialized Client, in order.
+func (m *Manager) UserIDs() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := make([]string, 0, len(m.clients))
+	for id := range m.clients {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// RateLimits returns the latest known rate limit of each user.
+func (m *Manager) RateLimits() map[string]RateLimit {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := make(map[string]RateLimit, len(m.clients))
+	for id, c := range m.clients {
+		r[id] = c.RateLimit()
+	}
+	return r
+}
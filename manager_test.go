@@ -0,0 +1,145 @@
+package bitfit
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestManagingClientsOfManyUsers(t *testing.T) {
+	newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer foo":
+			w.Header().Set("Fitbit-Rate-Limit-Remaining", "149")
+		case "Bearer qux":
+			w.Header().Set("Fitbit-Rate-Limit-Remaining", "12")
+		}
+		w.Header().Set("Fitbit-Rate-Limit-Limit", "150")
+		w.Header().Set("Fitbit-Rate-Limit-Reset", "600")
+		fmt.Fprint(w, `[]`)
+	})
+	store := DirTokenStore(t.TempDir())
+	m := NewManager("id", "secret", store)
+	exp := time.Now().Add(time.Hour)
+	for _, tt := range []Tokens{
+		{Access: "foo", Refresh: "bar", Expiration: exp, UserID: "BAZ"},
+		{Access: "qux", Refresh: "quux", Expiration: exp, UserID: "CORGE"},
+	} {
+		if _, err := m.Add(tt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.Add(Tokens{Access: "foo"}); err == nil {
+		t.Fatal("expected an error adding tokens without a user ID")
+	}
+
+	// A new manager of the same store stands in for a restart.
+	m = NewManager("id", "secret", store)
+	if err := m.LoadAll(); err != nil {
+		t.Fatal(err)
+	}
+	errFmt := "expected %v but received %v"
+	if e, a := "[BAZ CORGE]", fmt.Sprint(m.UserIDs()); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	for _, id := range m.UserIDs() {
+		c, err := m.Client(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.FetchDevices(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := m.RateLimits()
+	if e, a := 149, r["BAZ"].Remaining; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := 12, r["CORGE"].Remaining; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := 150, r["CORGE"].Limit; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if _, err := m.Client("../BAZ"); err == nil {
+		t.Fatal("expected an error for a user ID that is not a filename")
+	}
+}
+
+func TestAddingTokensOfUserWithClient(t *testing.T) {
+	store := DirTokenStore(t.TempDir())
+	m := NewManager("id", "secret", store)
+	exp := time.Now().Add(time.Hour)
+	c, err := m.Add(Tokens{Access: "foo", Refresh: "bar", Expiration: exp, UserID: "BAZ"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc, err := m.Add(Tokens{Access: "qux", Refresh: "quux", Expiration: exp, UserID: "BAZ"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != cc {
+		t.Fatal("expected the tokens to be swapped into the client the user already had")
+	}
+	errFmt := "expected %v but received %v"
+	if e, a := "qux", c.CurrentTokens().Access; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	saved, err := store.Load("BAZ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "quux", saved.Refresh; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+}
+
+func TestInitializingClientOnce(t *testing.T) {
+	var refreshes int32
+	newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/oauth2/token" {
+			t.Errorf("unexpected request for '%v'", r.URL)
+		}
+		// Refresh tokens can only be used once.
+		if atomic.AddInt32(&refreshes, 1) > 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"errors": [{"errorType": "invalid_grant", "message": "Refresh token invalid: bar"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"access_token": "qux", "refresh_token": "quux", "expires_in": 28800, "user_id": "BAZ"}`)
+	})
+	store := DirTokenStore(t.TempDir())
+	if err := store.Save("BAZ", Tokens{Access: "foo", Refresh: "bar", Expiration: time.Now().Add(-time.Hour), UserID: "BAZ"}); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager("id", "secret", store)
+	var wg sync.WaitGroup
+	clients := make([]*Client, 8)
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c, err := m.Client("BAZ")
+			if err != nil {
+				t.Error(err)
+			}
+			clients[i] = c
+		}(i)
+	}
+	wg.Wait()
+	errFmt := "expected %v but received %v"
+	if e, a := int32(1), atomic.LoadInt32(&refreshes); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	for _, c := range clients {
+		if c != clients[0] {
+			t.Fatal("expected every caller to receive the same client")
+		}
+	}
+	if e, a := "qux", clients[0].CurrentTokens().Access; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+}
@@ -0,0 +1,334 @@
+package bitfit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// MealType is the meal that a food was logged under, as numbered by the
+// Fitbit API. Food logs carry a date but no time of day, so the meal type
+// is the closest indication of when something was eaten.
+type MealType uint
+
+const (
+	Breakfast      MealType = 1
+	MorningSnack   MealType = 2
+	Lunch          MealType = 3
+	AfternoonSnack MealType = 4
+	Dinner         MealType = 5
+	Anytime        MealType = 7
+)
+
+func (m MealType) String() string {
+	switch m {
+	case Breakfast:
+		return "breakfast"
+	case MorningSnack:
+		return "morning snack"
+	case Lunch:
+		return "lunch"
+	case AfternoonSnack:
+		return "afternoon snack"
+	case Dinner:
+		return "dinner"
+	case Anytime:
+		return "anytime"
+	default:
+		return fmt.Sprintf("unknown meal type %d", uint(m))
+	}
+}
+
+type FoodUnit struct {
+	ID     uint
+	Name   string
+	Plural string
+}
+
+type NutritionalValues struct {
+	Calories float64
+	Carbs    float64
+	Fat      float64
+	Fiber    float64
+	Protein  float64
+	Sodium   float64
+}
+
+type Food struct {
+	ID          uint
+	Name        string
+	Brand       string
+	Calories    float64
+	AccessLevel string
+	Locale      string
+	DefaultUnit *FoodUnit
+	UnitIDs     []uint
+}
+
+func (f *Food) UnmarshalJSON(data []byte) error {
+	j := struct {
+		FoodID      uint
+		Name        string
+		Brand       string
+		Calories    float64
+		AccessLevel string
+		Locale      string
+		DefaultUnit *FoodUnit
+		Units       []uint
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	f.ID = j.FoodID
+	f.Name = j.Name
+	f.Brand = j.Brand
+	f.Calories = j.Calories
+	f.AccessLevel = j.AccessLevel
+	f.Locale = j.Locale
+	f.DefaultUnit = j.DefaultUnit
+	f.UnitIDs = j.Units
+	return nil
+}
+
+type FoodLogEntry struct {
+	ID                uint64
+	Date              time.Time
+	IsFavorite        bool
+	FoodID            uint
+	Name              string
+	Brand             string
+	Amount            float64
+	Unit              *FoodUnit
+	NutritionalValues *NutritionalValues
+	MealType
+}
+
+func (f *FoodLogEntry) UnmarshalJSON(data []byte) error {
+	j := struct {
+		LogID      uint64
+		LogDate    string
+		IsFavorite bool
+		LoggedFood struct {
+			FoodID     uint
+			Name       string
+			Brand      string
+			MealTypeID MealType
+			Amount     float64
+			Unit       *FoodUnit
+		}
+		NutritionalValues *NutritionalValues
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	var err error
+	if f.Date, err = parseDateInNYC(j.LogDate); err != nil {
+		return err
+	}
+	f.ID = j.LogID
+	f.IsFavorite = j.IsFavorite
+	f.FoodID = j.LoggedFood.FoodID
+	f.Name = j.LoggedFood.Name
+	f.Brand = j.LoggedFood.Brand
+	f.MealType = j.LoggedFood.MealTypeID
+	f.Amount = j.LoggedFood.Amount
+	f.Unit = j.LoggedFood.Unit
+	f.NutritionalValues = j.NutritionalValues
+	return nil
+}
+
+type FoodLogSummary struct {
+	NutritionalValues
+	Water float64
+}
+
+type FoodLog struct {
+	Entries      []FoodLogEntry
+	Summary      *FoodLogSummary
+	CaloriesGoal float64
+}
+
+func (f *FoodLog) UnmarshalJSON(data []byte) error {
+	j := struct {
+		Foods   []FoodLogEntry
+		Summary *FoodLogSummary
+		Goals   struct {
+			Calories float64
+		}
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	f.Entries = j.Foods
+	f.Summary = j.Summary
+	f.CaloriesGoal = j.Goals.Calories
+	return nil
+}
+
+type WaterLogEntry struct {
+	ID     uint64
+	Amount float64
+}
+
+func (w *WaterLogEntry) UnmarshalJSON(data []byte) error {
+	j := struct {
+		LogID  uint64
+		Amount float64
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	w.ID = j.LogID
+	w.Amount = j.Amount
+	return nil
+}
+
+type WaterLog struct {
+	Entries []WaterLogEntry
+	Total   float64
+}
+
+func (w *WaterLog) UnmarshalJSON(data []byte) error {
+	j := struct {
+		Water   []WaterLogEntry
+		Summary struct {
+			Water float64
+		}
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	w.Entries = j.Water
+	w.Total = j.Summary.Water
+	return nil
+}
+
+type FoodGoal struct {
+	Calories     float64
+	Intensity    string
+	Personalized bool
+	EstimateDate time.Time
+}
+
+func (f *FoodGoal) UnmarshalJSON(data []byte) error {
+	j := struct {
+		Goals struct {
+			Calories float64
+		}
+		FoodPlan *struct {
+			Intensity    string
+			Personalized bool
+			EstimateDate string
+		}
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	f.Calories = j.Goals.Calories
+	if j.FoodPlan == nil {
+		return nil
+	}
+	f.Intensity = j.FoodPlan.Intensity
+	f.Personalized = j.FoodPlan.Personalized
+	if j.FoodPlan.EstimateDate == "" {
+		return nil
+	}
+	var err error
+	f.EstimateDate, err = parseDateInNYC(j.FoodPlan.EstimateDate)
+	return err
+}
+
+type WaterGoal struct {
+	Amount    float64
+	StartDate time.Time
+}
+
+func (w *WaterGoal) UnmarshalJSON(data []byte) error {
+	j := struct {
+		Goal struct {
+			Goal      float64
+			StartDate string
+		}
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	w.Amount = j.Goal.Goal
+	if j.Goal.StartDate == "" {
+		return nil
+	}
+	var err error
+	w.StartDate, err = parseDateInNYC(j.Goal.StartDate)
+	return err
+}
+
+func (c *Client) FetchFoodLog(on time.Time) (FoodLog, error) {
+	s := apiURL("1/user/-/foods/log/date/%v.json")
+	f := FoodLog{}
+	if err := c.fetchInto(fmt.Sprintf(s, on.Format(dateFmt)), &f); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+func (c *Client) FetchWaterLog(on time.Time) (WaterLog, error) {
+	s := apiURL("1/user/-/foods/log/water/date/%v.json")
+	w := WaterLog{}
+	if err := c.fetchInto(fmt.Sprintf(s, on.Format(dateFmt)), &w); err != nil {
+		return w, err
+	}
+	return w, nil
+}
+
+// CreateWaterLog logs an amount of water drunk on the given date. The unit
+// may be "ml", "fl oz", or "cup", or empty to use the unit of the user's locale.
+func (c *Client) CreateWaterLog(on time.Time, amount float64, unit string) (WaterLogEntry, error) {
+	form := url.Values{}
+	form.Set("date", on.Format(dateFmt))
+	form.Set("amount", strconv.FormatFloat(amount, 'f', -1, 64))
+	if unit != "" {
+		form.Set("unit", unit)
+	}
+	j := struct {
+		WaterLog WaterLogEntry
+	}{}
+	if err := c.sendInto("POST", apiURL("1/user/-/foods/log/water.json"), form, &j); err != nil {
+		return WaterLogEntry{}, err
+	}
+	return j.WaterLog, nil
+}
+
+func (c *Client) DeleteWaterLog(id uint64) error {
+	s := apiURL("1/user/-/foods/log/water/%d.json")
+	_, err := c.send("DELETE", fmt.Sprintf(s, id), nil)
+	return err
+}
+
+func (c *Client) FetchFoodGoal() (FoodGoal, error) {
+	f := FoodGoal{}
+	if err := c.fetchInto(apiURL("1/user/-/foods/log/goal.json"), &f); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+func (c *Client) FetchWaterGoal() (WaterGoal, error) {
+	w := WaterGoal{}
+	if err := c.fetchInto(apiURL("1/user/-/foods/log/water/goal.json"), &w); err != nil {
+		return w, err
+	}
+	return w, nil
+}
+
+func (c *Client) SearchFoods(query string) ([]Food, error) {
+	j := struct {
+		Foods []Food
+	}{}
+	u := apiURL("1/foods/search.json?query=" + url.QueryEscape(query))
+	if err := c.fetchInto(u, &j); err != nil {
+		return nil, err
+	}
+	return j.Foods, nil
+}
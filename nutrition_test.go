@@ -0,0 +1,70 @@
+package bitfit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestUnmarshallingFoodLog(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/food_log_payload_20190916.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := new(FoodLog)
+	if err := json.Unmarshal(b, f); err != nil {
+		t.Fatal(err)
+	}
+	errFmt := "expected %v but received %v"
+	if e, a := 2, len(f.Entries); e != a {
+		t.Fatalf("expected %v food log entries but there were %v", e, a)
+	}
+	entry := f.Entries[0]
+	if e, a := "Coffee, Brewed", entry.Name; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := Dinner, entry.MealType; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := "cup", entry.Unit.Name; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := 0.7, entry.NutritionalValues.Protein; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	d, err := time.ParseInLocation("2006-01-02", "2019-09-16", newYork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, a := d.String(), entry.Date.String(); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := 750.0, f.Summary.Water; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := 575.0, f.Summary.Calories; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := 2285.0, f.CaloriesGoal; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+}
+
+func TestUnmarshallingWaterLog(t *testing.T) {
+	b := []byte(`{"summary":{"water":800},"water":[{"amount":500,"logId":1},{"amount":300,"logId":2}]}`)
+	w := new(WaterLog)
+	if err := json.Unmarshal(b, w); err != nil {
+		t.Fatal(err)
+	}
+	errFmt := "expected %v but received %v"
+	if e, a := 800.0, w.Total; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := 2, len(w.Entries); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := uint64(2), w.Entries[1].ID; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+}
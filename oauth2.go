@@ -0,0 +1,205 @@
+package bitfit
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AuthorizationURL is that of the page at which users authorize applications.
+var AuthorizationURL = "https://www.fitbit.com/oauth2/authorize"
+
+// AuthCodeURL returns the URL to send a user to, to authorize the
+// application with the scopes, from which the user is redirected to the
+// redirect URI with the state and a code to exchange for tokens with
+// ExchangeCode. The code is bound to the code verifier (as per RFC 7636),
+// which must be kept secret until the code is exchanged.
+func AuthCodeURL(id, redirectURI, state, codeVerifier string, scopes []string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", id)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", strings.Join(scopes, " "))
+	q.Set("state", state)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:]))
+	q.Set("code_challenge_method", "S256")
+	return AuthorizationURL + "?" + q.Encode()
+}
+
+// ExchangeCode exchanges an authorization code, as redirected to the
+// redirect URI with, for the tokens of the user who authorized the
+// application.
+func ExchangeCode(id, secret, code, redirectURI, codeVerifier string) (Tokens, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("client_id", id)
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("code_verifier", codeVerifier)
+	req, err := newFormRequest("POST", apiURL("oauth2/token"), form)
+	if err != nil {
+		return Tokens{}, err
+	}
+	req.Header.Add("Authorization", basicAuth(id, secret))
+	b, err := doOAuth2(req)
+	if err != nil {
+		return Tokens{}, fmt.Errorf("could not exchange authorization code: %v", err)
+	}
+	t := Tokens{}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return t, fmt.Errorf("could not unmarshal tokens of authorization code: %v", err)
+	}
+	return t, nil
+}
+
+// RevokeToken revokes an access or refresh token, which also revokes
+// every other token of the same authorization.
+func RevokeToken(id, secret, token string) error {
+	form := url.Values{}
+	form.Set("token", token)
+	req, err := newFormRequest("POST", apiURL("oauth2/revoke"), form)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Authorization", basicAuth(id, secret))
+	if _, err := doOAuth2(req); err != nil {
+		return fmt.Errorf("could not revoke token: %v", err)
+	}
+	return nil
+}
+
+// Revoke revokes the client's tokens, after which the user must authorize
+// the application again.
+func (c *Client) Revoke() error {
+	return RevokeToken(c.id, c.secret, c.tokens().Refresh)
+}
+
+// TokenInfo is the state of a token, as introspected by the Fitbit API.
+type TokenInfo struct {
+	Active     bool
+	Scopes     []string
+	ClientID   string
+	UserID     string
+	TokenType  string
+	Expiration time.Time
+	IssuedAt   time.Time
+}
+
+func (t *TokenInfo) UnmarshalJSON(data []byte) error {
+	j := struct {
+		Active     bool
+		Scope      string
+		Client_id  string
+		User_id    string
+		Token_type string
+		Exp        int64
+		Iat        int64
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	t.Active = j.Active
+	t.Scopes = parseIntrospectedScope(j.Scope)
+	t.ClientID = j.Client_id
+	t.UserID = j.User_id
+	t.TokenType = j.Token_type
+	if j.Exp != 0 {
+		t.Expiration = time.Unix(0, j.Exp*int64(time.Millisecond))
+	}
+	if j.Iat != 0 {
+		t.IssuedAt = time.Unix(0, j.Iat*int64(time.Millisecond))
+	}
+	return nil
+}
+
+// parseIntrospectedScope parses the scope of an introspected token, which
+// is formatted as a set of permissions per scope, e.g.
+// "{SLEEP=READ_WRITE, PROFILE=READ}", into the names of each scope as
+// they are formatted when authorizing, e.g. "sleep" and "profile".
+func parseIntrospectedScope(s string) []string {
+	a := make([]string, 0)
+	s = strings.Trim(strings.TrimSpace(s), "{}")
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		name := strings.SplitN(f, "=", 2)[0]
+		if name != "" {
+			a = append(a, strings.ToLower(name))
+		}
+	}
+	return a
+}
+
+// IntrospectToken introspects a token, authorized by an access token
+// (which may be the same token).
+func IntrospectToken(accessToken, token string) (TokenInfo, error) {
+	form := url.Values{}
+	form.Set("token", token)
+	req, err := newFormRequest("POST", apiURL("1.1/oauth2/introspect"), form)
+	if err != nil {
+		return TokenInfo{}, err
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", accessToken))
+	b, err := doOAuth2(req)
+	if err != nil {
+		return TokenInfo{}, fmt.Errorf("could not introspect token: %v", err)
+	}
+	t := TokenInfo{}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return t, fmt.Errorf("could not unmarshal introspected token: %v", err)
+	}
+	return t, nil
+}
+
+// Introspect introspects the client's access token.
+func (c *Client) Introspect() (TokenInfo, error) {
+	form := url.Values{}
+	form.Set("token", c.tokens().Access)
+	t := TokenInfo{}
+	if err := c.sendInto("POST", apiURL("1.1/oauth2/introspect"), form, &t); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// doOAuth2 makes a request to an OAuth2 endpoint, which (unlike other
+// endpoints) is not authorized by a Client, and returns the response body.
+func doOAuth2(req *http.Request) ([]byte, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return []byte{}, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return []byte{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		s := "did not receive HTTP status OK (200) from '%s' : %s"
+		err := fmt.Errorf(s, req.URL, resp.Status)
+		if m := errorMessage(b); m != "" {
+			err = fmt.Errorf("%v: %v", err, m)
+		}
+		return b, err
+	}
+	return b, nil
+}
+
+// errorMessage returns the message of the first error in a response body
+// of the Fitbit API, if any.
+func errorMessage(respBody []byte) string {
+	j := struct {
+		Errors []struct {
+			Message string
+		}
+	}{}
+	if err := json.Unmarshal(respBody, &j); err != nil || len(j.Errors) == 0 {
+		return ""
+	}
+	return j.Errors[0].Message
+}
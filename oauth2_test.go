@@ -0,0 +1,116 @@
+package bitfit
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestIntrospectingToken(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		switch {
+		case r.URL.Path != "/1.1/oauth2/introspect":
+			t.Errorf("unexpected request for '%v'", r.URL)
+		case r.Form.Get("token") == "foo":
+			fmt.Fprint(w, `{"active": true, "scope": "{SLEEP=READ_WRITE, PROFILE=READ}", "client_id": "ABC", "user_id": "BAZ", "token_type": "access_token", "exp": 1569932905000, "iat": 1569904105000}`)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"errors": [{"errorType": "invalid_token", "message": "Access token invalid: bar"}], "success": false}`)
+		}
+	})
+	i, err := c.Introspect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	errFmt := "expected %v but received %v"
+	if e, a := true, i.Active; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := "sleep profile", strings.Join(i.Scopes, " "); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := "BAZ", i.UserID; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := int64(1569932905), i.Expiration.Unix(); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	_, err = IntrospectToken("foo", "bar")
+	if err == nil || !strings.Contains(err.Error(), "Access token invalid") {
+		t.Fatalf("expected the error message of the response but received %v", err)
+	}
+}
+
+func TestExchangingCode(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		switch {
+		case r.URL.Path != "/oauth2/token":
+			t.Errorf("unexpected request for '%v'", r.URL)
+		case r.Header.Get("Authorization") != basicAuth("id", "secret"):
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.Form.Get("grant_type") == "authorization_code" && r.Form.Get("code") == "foo" && r.Form.Get("code_verifier") == "bar":
+			fmt.Fprint(w, `{"access_token": "qux", "refresh_token": "quux", "expires_in": 28800, "scope": "sleep profile", "user_id": "BAZ"}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"errors": [{"errorType": "invalid_grant", "message": "Authorization code invalid: foo"}], "success": false}`)
+		}
+	})
+	tokens, err := ExchangeCode("id", "secret", "foo", "https://example.com/callback", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errFmt := "expected %v but received %v"
+	if e, a := "qux quux BAZ", tokens.Access+" "+tokens.Refresh+" "+tokens.UserID; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	_, err = ExchangeCode("id", "secret", "foo", "https://example.com/callback", "corge")
+	if err == nil || !strings.Contains(err.Error(), "Authorization code invalid") {
+		t.Fatalf("expected the error message of the response but received %v", err)
+	}
+
+	var saved Tokens
+	c.TokensSaver = func(t Tokens) error {
+		saved = t
+		return nil
+	}
+	if err := c.SetTokens(tokens); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "qux", c.CurrentTokens().Access; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := "qux", saved.Access; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	// The example of RFC 7636, Appendix B.
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	u, err := url.Parse(AuthCodeURL("id", "https://example.com/callback", "foo", verifier, []string{"sleep", "profile"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errFmt := "expected %v but received %v"
+	q := u.Query()
+	for k, e := range map[string]string{
+		"client_id":             "id",
+		"redirect_uri":          "https://example.com/callback",
+		"scope":                 "sleep profile",
+		"state":                 "foo",
+		"code_challenge":        "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		"code_challenge_method": "S256",
+	} {
+		if a := q.Get(k); e != a {
+			t.Fatalf(errFmt, e, a)
+		}
+	}
+}
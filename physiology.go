@@ -0,0 +1,291 @@
+package bitfit
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// HRV is the heart rate variability measured during a night's main sleep,
+// as the root mean square of successive differences (RMSSD) in milliseconds.
+type HRV struct {
+	Date       time.Time
+	DailyRMSSD float64
+	DeepRMSSD  float64
+}
+
+func (h *HRV) UnmarshalJSON(data []byte) error {
+	j := struct {
+		DateTime string
+		Value    struct {
+			DailyRmssd float64
+			DeepRmssd  float64
+		}
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	var err error
+	if h.Date, err = parseDateInNYC(j.DateTime); err != nil {
+		return err
+	}
+	h.DailyRMSSD = j.Value.DailyRmssd
+	h.DeepRMSSD = j.Value.DeepRmssd
+	return nil
+}
+
+// SpO2 is the percentage of oxygen saturation of the blood during a night's sleep.
+type SpO2 struct {
+	Date time.Time
+	Avg  float64
+	Min  float64
+	Max  float64
+}
+
+func (s *SpO2) UnmarshalJSON(data []byte) error {
+	j := struct {
+		DateTime string
+		Value    struct {
+			Avg float64
+			Min float64
+			Max float64
+		}
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	var err error
+	if s.Date, err = parseDateInNYC(j.DateTime); err != nil {
+		return err
+	}
+	s.Avg = j.Value.Avg
+	s.Min = j.Value.Min
+	s.Max = j.Value.Max
+	return nil
+}
+
+// BreathingRate is the average breaths per minute during a night's sleep.
+type BreathingRate struct {
+	Date time.Time
+	Rate float64
+}
+
+func (b *BreathingRate) UnmarshalJSON(data []byte) error {
+	j := struct {
+		DateTime string
+		Value    struct {
+			BreathingRate float64
+		}
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	var err error
+	if b.Date, err = parseDateInNYC(j.DateTime); err != nil {
+		return err
+	}
+	b.Rate = j.Value.BreathingRate
+	return nil
+}
+
+// SkinTemperature is the variation in skin temperature during a night's
+// sleep, in degrees relative to the user's baseline.
+type SkinTemperature struct {
+	Date            time.Time
+	NightlyRelative float64
+	LogType         string
+}
+
+func (s *SkinTemperature) UnmarshalJSON(data []byte) error {
+	j := struct {
+		DateTime string
+		LogType  string
+		Value    struct {
+			NightlyRelative float64
+		}
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	var err error
+	if s.Date, err = parseDateInNYC(j.DateTime); err != nil {
+		return err
+	}
+	s.NightlyRelative = j.Value.NightlyRelative
+	s.LogType = j.LogType
+	return nil
+}
+
+func (c *Client) FetchHRV(on time.Time) ([]HRV, error) {
+	s := apiURL("1/user/-/hrv/date/%v.json")
+	return c.fetchHRV(fmt.Sprintf(s, on.Format(dateFmt)))
+}
+
+func (c *Client) FetchHRVInterval(from, to time.Time) ([]HRV, error) {
+	s := apiURL("1/user/-/hrv/date/%v/%v.json")
+	return c.fetchHRV(fmt.Sprintf(s, from.Format(dateFmt), to.Format(dateFmt)))
+}
+
+func (c *Client) fetchHRV(url string) ([]HRV, error) {
+	j := struct {
+		HRV []HRV
+	}{}
+	if err := c.fetchInto(url, &j); err != nil {
+		return nil, err
+	}
+	return j.HRV, nil
+}
+
+func (c *Client) FetchSpO2(on time.Time) ([]SpO2, error) {
+	s := apiURL("1/user/-/spo2/date/%v.json")
+	// A single date is served as a lone object, which is empty if there is no data.
+	j := struct {
+		DateTime string
+	}{}
+	b, err := c.fetch(fmt.Sprintf(s, on.Format(dateFmt)))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &j); err != nil {
+		return nil, err
+	}
+	if j.DateTime == "" {
+		return []SpO2{}, nil
+	}
+	o := SpO2{}
+	if err := json.Unmarshal(b, &o); err != nil {
+		return nil, err
+	}
+	return []SpO2{o}, nil
+}
+
+func (c *Client) FetchSpO2Interval(from, to time.Time) ([]SpO2, error) {
+	s := apiURL("1/user/-/spo2/date/%v/%v.json")
+	a := make([]SpO2, 0)
+	if err := c.fetchInto(fmt.Sprintf(s, from.Format(dateFmt), to.Format(dateFmt)), &a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (c *Client) FetchBreathingRate(on time.Time) ([]BreathingRate, error) {
+	s := apiURL("1/user/-/br/date/%v.json")
+	return c.fetchBreathingRate(fmt.Sprintf(s, on.Format(dateFmt)))
+}
+
+func (c *Client) FetchBreathingRateInterval(from, to time.Time) ([]BreathingRate, error) {
+	s := apiURL("1/user/-/br/date/%v/%v.json")
+	return c.fetchBreathingRate(fmt.Sprintf(s, from.Format(dateFmt), to.Format(dateFmt)))
+}
+
+func (c *Client) fetchBreathingRate(url string) ([]BreathingRate, error) {
+	j := struct {
+		BR []BreathingRate
+	}{}
+	if err := c.fetchInto(url, &j); err != nil {
+		return nil, err
+	}
+	return j.BR, nil
+}
+
+func (c *Client) FetchSkinTemperature(on time.Time) ([]SkinTemperature, error) {
+	s := apiURL("1/user/-/temp/skin/date/%v.json")
+	return c.fetchSkinTemperature(fmt.Sprintf(s, on.Format(dateFmt)))
+}
+
+func (c *Client) FetchSkinTemperatureInterval(from, to time.Time) ([]SkinTemperature, error) {
+	s := apiURL("1/user/-/temp/skin/date/%v/%v.json")
+	return c.fetchSkinTemperature(fmt.Sprintf(s, from.Format(dateFmt), to.Format(dateFmt)))
+}
+
+func (c *Client) fetchSkinTemperature(url string) ([]SkinTemperature, error) {
+	j := struct {
+		TempSkin []SkinTemperature
+	}{}
+	if err := c.fetchInto(url, &j); err != nil {
+		return nil, err
+	}
+	return j.TempSkin, nil
+}
+
+// Physiology gathers the nightly metrics that Fitbit derives from a main
+// sleep, any of which are nil if the device recorded no such data.
+type Physiology struct {
+	Date            time.Time
+	HRV             *HRV
+	SpO2            *SpO2
+	BreathingRate   *BreathingRate
+	SkinTemperature *SkinTemperature
+}
+
+// FetchPhysiology fetches each kind of nightly metric for the dates from
+// and to (inclusive) and collates them by date, in chronological order.
+func (c *Client) FetchPhysiology(from, to time.Time) ([]Physiology, error) {
+	hrv, err := c.FetchHRVInterval(from, to)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch HRV: %v", err)
+	}
+	spo2, err := c.FetchSpO2Interval(from, to)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch SpO2: %v", err)
+	}
+	br, err := c.FetchBreathingRateInterval(from, to)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch breathing rate: %v", err)
+	}
+	temp, err := c.FetchSkinTemperatureInterval(from, to)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch skin temperature: %v", err)
+	}
+	return CollatePhysiology(hrv, spo2, br, temp), nil
+}
+
+// CollatePhysiology groups nightly metrics by their date.
+func CollatePhysiology(hrv []HRV, spo2 []SpO2, br []BreathingRate, temp []SkinTemperature) []Physiology {
+	byDate := make(map[string]*Physiology)
+	get := func(d time.Time) *Physiology {
+		p, ok := byDate[d.Format(dateFmt)]
+		if !ok {
+			p = &Physiology{Date: d}
+			byDate[d.Format(dateFmt)] = p
+		}
+		return p
+	}
+	for i := range hrv {
+		get(hrv[i].Date).HRV = &hrv[i]
+	}
+	for i := range spo2 {
+		get(spo2[i].Date).SpO2 = &spo2[i]
+	}
+	for i := range br {
+		get(br[i].Date).BreathingRate = &br[i]
+	}
+	for i := range temp {
+		get(temp[i].Date).SkinTemperature = &temp[i]
+	}
+	a := make([]Physiology, 0, len(byDate))
+	for _, p := range byDate {
+		a = append(a, *p)
+	}
+	sort.Slice(a, func(i, j int) bool { return a[i].Date.Before(a[j].Date) })
+	return a
+}
+
+// AttachPhysiology sets the Physiology of each primary session in the
+// sleep log to that of the same date of sleep, since Fitbit only derives
+// nightly metrics from a main sleep.
+func (s *SleepLog) AttachPhysiology(p []Physiology) {
+	for i := range s.Sessions {
+		sess := &s.Sessions[i]
+		if !sess.IsPrimary {
+			continue
+		}
+		for j := range p {
+			if p[j].Date.Equal(sess.DateOfSleep) {
+				sess.Physiology = &p[j]
+				break
+			}
+		}
+	}
+}
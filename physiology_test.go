@@ -0,0 +1,50 @@
+package bitfit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+func TestAttachingPhysiologyToSleepLog(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/sleep_log_payload_20190916.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := new(SleepLog)
+	if err := json.Unmarshal(b, s); err != nil {
+		t.Fatal(err)
+	}
+	hrv := struct{ HRV []HRV }{}
+	b = []byte(`{"hrv":[{"value":{"dailyRmssd":34.9,"deepRmssd":31.5},"dateTime":"2019-09-16"},{"value":{"dailyRmssd":40.1,"deepRmssd":38.2},"dateTime":"2019-09-15"}]}`)
+	if err := json.Unmarshal(b, &hrv); err != nil {
+		t.Fatal(err)
+	}
+	var spo2 []SpO2
+	b = []byte(`[{"dateTime":"2019-09-16","value":{"avg":97.5,"min":94.0,"max":100.0}}]`)
+	if err := json.Unmarshal(b, &spo2); err != nil {
+		t.Fatal(err)
+	}
+	p := CollatePhysiology(hrv.HRV, spo2, nil, nil)
+	if e, a := 2, len(p); e != a {
+		t.Fatalf("expected %v dates of physiology but there were %v", e, a)
+	}
+	if !p[0].Date.Before(p[1].Date) {
+		t.Fatalf("expected '%v' to be earlier in time than '%v'", p[0].Date, p[1].Date)
+	}
+	s.AttachPhysiology(p)
+	sess := s.Sessions[0]
+	if sess.Physiology == nil {
+		t.Fatal("expected physiology to be attached to the primary session")
+	}
+	errFmt := "expected %v but received %v"
+	if e, a := 34.9, sess.Physiology.HRV.DailyRMSSD; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := 94.0, sess.Physiology.SpO2.Min; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if sess.Physiology.BreathingRate != nil {
+		t.Fatalf("expected no breathing rate but received %+v", sess.Physiology.BreathingRate)
+	}
+}
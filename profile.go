@@ -0,0 +1,222 @@
+package bitfit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type Profile struct {
+	UserID                 string
+	DisplayName            string
+	FullName               string
+	FirstName              string
+	LastName               string
+	AboutMe                string
+	Avatar                 string
+	Gender                 string
+	DateOfBirth            time.Time
+	Age                    uint
+	Height                 float64
+	Weight                 float64
+	City                   string
+	State                  string
+	Country                string
+	Timezone               string
+	OffsetFromUTC          time.Duration
+	Locale                 string
+	DistanceUnit           string
+	HeightUnit             string
+	WeightUnit             string
+	WaterUnit              string
+	GlucoseUnit            string
+	SwimUnit               string
+	TemperatureUnit        string
+	StrideLengthWalking    float64
+	StrideLengthRunning    float64
+	MemberSince            time.Time
+	StartDayOfWeek         time.Weekday
+	ClockTimeDisplayFormat string
+	AverageDailySteps      uint
+}
+
+func (p *Profile) UnmarshalJSON(data []byte) error {
+	j := struct {
+		User *struct {
+			EncodedID              string
+			DisplayName            string
+			FullName               string
+			FirstName              string
+			LastName               string
+			AboutMe                string
+			Avatar                 string
+			Gender                 string
+			DateOfBirth            string
+			Age                    uint
+			Height                 float64
+			Weight                 float64
+			City                   string
+			State                  string
+			Country                string
+			Timezone               string
+			OffsetFromUTCMillis    int64
+			Locale                 string
+			DistanceUnit           string
+			HeightUnit             string
+			WeightUnit             string
+			WaterUnit              string
+			GlucoseUnit            string
+			SwimUnit               string
+			TemperatureUnit        string
+			StrideLengthWalking    float64
+			StrideLengthRunning    float64
+			MemberSince            string
+			StartDayOfWeek         string
+			ClockTimeDisplayFormat string
+			AverageDailySteps      uint
+		}
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	u := j.User
+	if u == nil {
+		return errors.New("no user field present in profile")
+	}
+	p.UserID = u.EncodedID
+	p.DisplayName = u.DisplayName
+	p.FullName = u.FullName
+	p.FirstName = u.FirstName
+	p.LastName = u.LastName
+	p.AboutMe = u.AboutMe
+	p.Avatar = u.Avatar
+	p.Gender = u.Gender
+	p.Age = u.Age
+	p.Height = u.Height
+	p.Weight = u.Weight
+	p.City = u.City
+	p.State = u.State
+	p.Country = u.Country
+	p.Timezone = u.Timezone
+	p.OffsetFromUTC = time.Duration(u.OffsetFromUTCMillis) * time.Millisecond
+	p.Locale = u.Locale
+	p.DistanceUnit = u.DistanceUnit
+	p.HeightUnit = u.HeightUnit
+	p.WeightUnit = u.WeightUnit
+	p.WaterUnit = u.WaterUnit
+	p.GlucoseUnit = u.GlucoseUnit
+	p.SwimUnit = u.SwimUnit
+	p.TemperatureUnit = u.TemperatureUnit
+	p.StrideLengthWalking = u.StrideLengthWalking
+	p.StrideLengthRunning = u.StrideLengthRunning
+	p.ClockTimeDisplayFormat = u.ClockTimeDisplayFormat
+	p.AverageDailySteps = u.AverageDailySteps
+	var err error
+	if u.DateOfBirth != "" {
+		if p.DateOfBirth, err = parseDateInNYC(u.DateOfBirth); err != nil {
+			return err
+		}
+	}
+	if u.MemberSince != "" {
+		if p.MemberSince, err = parseDateInNYC(u.MemberSince); err != nil {
+			return err
+		}
+	}
+	if u.StartDayOfWeek != "" {
+		if p.StartDayOfWeek, err = parseWeekday(u.StartDayOfWeek); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Location loads the location of the user's configured timezone.
+func (p Profile) Location() (*time.Location, error) {
+	if p.Timezone == "" {
+		return nil, errors.New("no timezone is set on profile")
+	}
+	return time.LoadLocation(p.Timezone)
+}
+
+func (c *Client) FetchUserProfile() (Profile, error) {
+	p := Profile{}
+	if err := c.fetchInto(apiURL("1/user/-/profile.json"), &p); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// ProfileUpdate holds the fields of a profile to be updated, where fields
+// left as their zero value are left unchanged.
+type ProfileUpdate struct {
+	FullName               string
+	AboutMe                string
+	Gender                 string
+	Birthday               time.Time
+	Height                 float64
+	City                   string
+	State                  string
+	Country                string
+	Timezone               string
+	Locale                 string
+	FoodsLocale            string
+	HeightUnit             string
+	WeightUnit             string
+	WaterUnit              string
+	GlucoseUnit            string
+	StrideLengthWalking    float64
+	StrideLengthRunning    float64
+	StartDayOfWeek         string
+	ClockTimeDisplayFormat string
+}
+
+func (u ProfileUpdate) form() url.Values {
+	form := url.Values{}
+	set := func(k, v string) {
+		if v != "" {
+			form.Set(k, v)
+		}
+	}
+	setFloat := func(k string, f float64) {
+		if f != 0 {
+			form.Set(k, strconv.FormatFloat(f, 'f', -1, 64))
+		}
+	}
+	set("fullname", u.FullName)
+	set("aboutMe", u.AboutMe)
+	set("gender", u.Gender)
+	if !u.Birthday.IsZero() {
+		form.Set("birthday", u.Birthday.Format(dateFmt))
+	}
+	setFloat("height", u.Height)
+	set("city", u.City)
+	set("state", u.State)
+	set("country", u.Country)
+	set("timezone", u.Timezone)
+	set("locale", u.Locale)
+	set("foodsLocale", u.FoodsLocale)
+	set("heightUnit", u.HeightUnit)
+	set("weightUnit", u.WeightUnit)
+	set("waterUnit", u.WaterUnit)
+	set("glucoseUnit", u.GlucoseUnit)
+	setFloat("strideLengthWalking", u.StrideLengthWalking)
+	setFloat("strideLengthRunning", u.StrideLengthRunning)
+	set("startDayOfWeek", u.StartDayOfWeek)
+	set("clockTimeDisplayFormat", u.ClockTimeDisplayFormat)
+	return form
+}
+
+// UpdateProfile updates the user's profile and returns it as it was saved.
+func (c *Client) UpdateProfile(u ProfileUpdate) (Profile, error) {
+	form := u.form()
+	if len(form) == 0 {
+		return Profile{}, errors.New("no profile fields to update were provided")
+	}
+	p := Profile{}
+	if err := c.sendInto("POST", apiURL("1/user/-/profile.json"), form, &p); err != nil {
+		return p, err
+	}
+	return p, nil
+}
@@ -0,0 +1,45 @@
+package bitfit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestUnmarshallingProfile(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/profile_payload.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := new(Profile)
+	if err := json.Unmarshal(b, p); err != nil {
+		t.Fatal(err)
+	}
+	errFmt := "expected %v but received %v"
+	if e, a := "BAZ", p.UserID; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := "Foo B.", p.DisplayName; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := -4*time.Hour, p.OffsetFromUTC; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := 73.7, p.StrideLengthWalking; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := time.Sunday, p.StartDayOfWeek; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := "2016-03-12", p.MemberSince.Format("2006-01-02"); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	loc, err := p.Location()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "America/New_York", loc.String(); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+}
@@ -12,6 +12,10 @@ type Args struct {
 	BaseURL  *string
 	Username *string
 	Password *string
+	// Key is an API key issued by the proxy server (see the keys subcommand
+	// of bitfit/cmd/serveproxy), to authenticate with instead of a username
+	// and password.
+	Key *string
 }
 
 // TODO(aoeu): Require caller to set config,
@@ -23,6 +27,7 @@ func ArgsWithFlagSet(fs *flag.FlagSet, configDefault string) Args {
 		fs.String("url", "", "the base URL of the proxy server"),
 		fs.String("username", "", "A username"+s),
 		fs.String("password", "", "A password"+s),
+		fs.String("key", "", "An API key issued by the proxy server, to authenticate with instead of a username and password"),
 	}
 }
 
@@ -30,6 +35,8 @@ func (a Args) Validate() error {
 	switch {
 	case *a.BaseURL == "":
 		return fmt.Errorf("no proxy URL\n")
+	case *a.Key != "":
+		return nil
 	case *a.Username == "":
 		return fmt.Errorf("no username provided\n")
 	case *a.Password == "":
@@ -72,6 +79,19 @@ func NewClient(username, password string) *bitfit.Client {
 	return c
 }
 
+// NewKeyClient is like NewClient but authenticates with the reverse-proxy
+// server by an API key as a bearer token instead of by HTTP basic auth, so
+// that the password of the proxy's user need not be shared with the client.
+// The requests the client can make are limited to the scopes of the key.
+func NewKeyClient(key string) *bitfit.Client {
+	c := NewClient("", "")
+	c.Authorizer = func(r *http.Request) error {
+		r.Header.Set("Authorization", "Bearer "+key)
+		return nil
+	}
+	return c
+}
+
 // Init initializes the bitfit package to make requests just as it would
 // to the FitBit API, but with HTTP basic authentication used to send the
 // request to a reverse-proxy server that handles OAuth2 authorization on
@@ -85,3 +105,14 @@ func Init(baseURL, username, password string) error {
 	bitfit.BaseURL = baseURL
 	return nil
 }
+
+// InitWithKey is like Init but authenticates with an API key.
+func InitWithKey(baseURL, key string) error {
+	c := NewKeyClient(key)
+	if err := c.Init(); err != nil {
+		return err
+	}
+	bitfit.DefaultClient = c
+	bitfit.BaseURL = baseURL
+	return nil
+}
@@ -0,0 +1,62 @@
+package bitfit
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimit is the state of a user's hourly quota of requests to the
+// Fitbit API, as of the latest response that reported it.
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+	Updated   time.Time
+}
+
+// Known reports whether any response has reported the rate limit yet.
+func (r RateLimit) Known() bool {
+	return !r.Updated.IsZero()
+}
+
+// parseRateLimit parses the rate limit headers of a response, reporting
+// false if they are absent or malformed.
+func parseRateLimit(h http.Header, now time.Time) (RateLimit, bool) {
+	limit, err := strconv.Atoi(h.Get("Fitbit-Rate-Limit-Limit"))
+	if err != nil {
+		return RateLimit{}, false
+	}
+	remaining, err := strconv.Atoi(h.Get("Fitbit-Rate-Limit-Remaining"))
+	if err != nil {
+		return RateLimit{}, false
+	}
+	reset, err := strconv.Atoi(h.Get("Fitbit-Rate-Limit-Reset"))
+	if err != nil {
+		return RateLimit{}, false
+	}
+	return RateLimit{
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     now.Add(time.Duration(reset) * time.Second),
+		Updated:   now,
+	}, true
+}
+
+func (c *Client) recordRateLimit(h http.Header) {
+	r, ok := parseRateLimit(h, time.Now())
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rateLimit = r
+}
+
+// RateLimit returns the state of the user's quota of requests as of the
+// latest response to the client.
+func (c *Client) RateLimit() RateLimit {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rateLimit
+}
@@ -0,0 +1,73 @@
+package bitfit
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// InsufficientScopeError is returned instead of making a request that the
+// scopes granted to the tokens do not permit, in which case the user must
+// authorize the application again with the required scope.
+type InsufficientScopeError struct {
+	Required string
+	Granted  []string
+	URL      string
+}
+
+func (e *InsufficientScopeError) Error() string {
+	s := "the '%v' scope is required to request '%v' but only '%v' was granted; re-authorize the application with the '%v' scope"
+	return fmt.Sprintf(s, e.Required, e.URL, strings.Join(e.Granted, " "), e.Required)
+}
+
+// resourceScopes maps the resources of users to the scope each requires.
+var resourceScopes = map[string]string{
+	"activities":  "activity",
+	"badges":      "profile",
+	"br":          "respiratory_rate",
+	"cardioscore": "cardio_fitness",
+	"body":        "weight",
+	"devices":     "settings",
+	"ecg":         "electrocardiogram",
+	"foods":       "nutrition",
+	"friends":     "social",
+	"hrv":         "heartrate",
+	"irn":         "irregular_rhythm_notifications",
+	"leaderboard": "social",
+	"profile":     "profile",
+	"sleep":       "sleep",
+	"spo2":        "oxygen_saturation",
+	"temp":        "temperature",
+}
+
+// RequiredScope returns the scope required to request the resource at the
+// URL, or an empty string if there is none or it is unknown.
+func RequiredScope(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	// e.g. "1.2", "user", "-", "sleep", "date", "2019-09-16.json"
+	p := strings.Split(strings.TrimPrefix(u.Path, "/"), "/")
+	switch {
+	case len(p) >= 3 && p[1] == "foods":
+		// The food database is not a resource of a user.
+		return "nutrition"
+	case len(p) < 4 || p[1] != "user":
+		return ""
+	case p[3] == "activities" && len(p) > 4 && strings.HasPrefix(p[4], "heart"):
+		return "heartrate"
+	}
+	return resourceScopes[strings.TrimSuffix(p[3], ".json")]
+}
+
+// checkScope returns an InsufficientScopeError if the client's tokens
+// were not granted the scope required to request the resource at the URL.
+func (c *Client) checkScope(rawURL string) error {
+	s := RequiredScope(rawURL)
+	t := c.tokens()
+	if s == "" || t.HasScope(s) {
+		return nil
+	}
+	return &InsufficientScopeError{Required: s, Granted: t.Scopes, URL: rawURL}
+}
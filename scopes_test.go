@@ -0,0 +1,46 @@
+package bitfit
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRequiredScopes(t *testing.T) {
+	for u, e := range map[string]string{
+		"https://api.fitbit.com/1.2/user/-/sleep/date/2019-09-16.json":        "sleep",
+		"https://api.fitbit.com/1/user/-/profile.json":                        "profile",
+		"https://api.fitbit.com/1/user/-/activities/heart/date/today/1d.json": "heartrate",
+		"https://api.fitbit.com/1/user/-/activities/goals/daily.json":         "activity",
+		"https://api.fitbit.com/1/foods/search.json?query=coffee":             "nutrition",
+		"https://api.fitbit.com/1/user/-/temp/skin/date/2019-09-16.json":      "temperature",
+		"https://api.fitbit.com/1.1/oauth2/introspect":                        "",
+		"https://api.fitbit.com/1/user/-/apiSubscriptions.json":               "",
+		"https://api.fitbit.com/1/user/-/devices/tracker/1/alarms.json":       "settings",
+		"https://api.fitbit.com/1.1/user/-/leaderboard/friends.json":          "social",
+	} {
+		if a := RequiredScope(u); e != a {
+			t.Fatalf("expected scope '%v' for '%v' but received '%v'", e, u, a)
+		}
+	}
+}
+
+func TestCheckingScopeBeforeRequest(t *testing.T) {
+	requested := false
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	})
+	c.Scopes = []string{"profile", "sleep"}
+	_, err := c.FetchHRV(time.Now())
+	var e *InsufficientScopeError
+	if !errors.As(err, &e) {
+		t.Fatalf("expected an insufficient scope error but received %v", err)
+	}
+	if e, a := "heartrate", e.Required; e != a {
+		t.Fatalf("expected %v but received %v", e, a)
+	}
+	if requested {
+		t.Fatal("expected no request to be made without the required scope")
+	}
+}
@@ -0,0 +1,207 @@
+package bitfit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ClockTime is a time of day, without a date or location.
+type ClockTime struct {
+	Hour   int
+	Minute int
+}
+
+// ParseClockTime parses a time of day formatted as "HH:mm", e.g. "22:30".
+func ParseClockTime(s string) (ClockTime, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return ClockTime{}, fmt.Errorf("could not parse '%v' as a time of day: %v", s, err)
+	}
+	return ClockTime{t.Hour(), t.Minute()}, nil
+}
+
+func (c ClockTime) String() string {
+	return fmt.Sprintf("%02d:%02d", c.Hour, c.Minute)
+}
+
+// On returns the clock time on the same date and in the same location as t.
+func (c ClockTime) On(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, c.Hour, c.Minute, 0, 0, t.Location())
+}
+
+// nearest returns the occurrence of the clock time closest to t, which
+// may be on the date before or after that of t.
+func (c ClockTime) nearest(t time.Time) time.Time {
+	n := c.On(t)
+	for _, d := range []int{-1, 1} {
+		o := c.On(t.AddDate(0, 0, d))
+		if abs(o.Sub(t)) < abs(n.Sub(t)) {
+			n = o
+		}
+	}
+	return n
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// SleepConsistency is Fitbit's estimate of the user's sleep habits, from
+// which it recommends a sleep goal.
+type SleepConsistency struct {
+	RecommendedSleepGoal    time.Duration
+	TypicalDuration         time.Duration
+	TypicalWakeupTime       *ClockTime
+	AwakeRestlessPercentage float64
+}
+
+// SleepGoal is the minimum duration of sleep a user aims for each night,
+// along with any bedtime and wakeup time targets of their sleep schedule.
+type SleepGoal struct {
+	MinDuration time.Duration
+	Bedtime     *ClockTime
+	WakeupTime  *ClockTime
+	UpdatedOn   time.Time
+	Consistency *SleepConsistency
+}
+
+func (g *SleepGoal) UnmarshalJSON(data []byte) error {
+	j := struct {
+		Goal struct {
+			MinDuration uint
+			Bedtime     string
+			WakeupTime  string
+			UpdatedOn   string
+		}
+		Consistency *struct {
+			RecommendedSleepGoal    uint
+			TypicalDuration         uint
+			TypicalWakeupTime       string
+			AwakeRestlessPercentage float64
+		}
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	var err error
+	if g.MinDuration, err = parseMin(j.Goal.MinDuration); err != nil {
+		return err
+	}
+	if g.Bedtime, err = parseOptionalClockTime(j.Goal.Bedtime); err != nil {
+		return err
+	}
+	if g.WakeupTime, err = parseOptionalClockTime(j.Goal.WakeupTime); err != nil {
+		return err
+	}
+	if j.Goal.UpdatedOn != "" {
+		if g.UpdatedOn, err = parseInNYC(j.Goal.UpdatedOn); err != nil {
+			return err
+		}
+	}
+	if j.Consistency == nil {
+		return nil
+	}
+	c := &SleepConsistency{AwakeRestlessPercentage: j.Consistency.AwakeRestlessPercentage}
+	if c.RecommendedSleepGoal, err = parseMin(j.Consistency.RecommendedSleepGoal); err != nil {
+		return err
+	}
+	if c.TypicalDuration, err = parseMin(j.Consistency.TypicalDuration); err != nil {
+		return err
+	}
+	if c.TypicalWakeupTime, err = parseOptionalClockTime(j.Consistency.TypicalWakeupTime); err != nil {
+		return err
+	}
+	g.Consistency = c
+	return nil
+}
+
+func parseOptionalClockTime(s string) (*ClockTime, error) {
+	if s == "" {
+		return nil, nil
+	}
+	c, err := ParseClockTime(s)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *Client) FetchSleepGoal() (SleepGoal, error) {
+	g := SleepGoal{}
+	if err := c.fetchInto(apiURL("1.2/user/-/sleep/goal.json"), &g); err != nil {
+		return g, err
+	}
+	return g, nil
+}
+
+// UpdateSleepGoal sets the minimum duration of sleep to aim for and, if
+// set on the goal, the bedtime and wakeup time targets.
+func (c *Client) UpdateSleepGoal(g SleepGoal) (SleepGoal, error) {
+	if g.MinDuration <= 0 {
+		return SleepGoal{}, errors.New("a minimum duration of sleep is required to update a sleep goal")
+	}
+	form := url.Values{}
+	form.Set("minDuration", strconv.Itoa(int(g.MinDuration.Minutes())))
+	if g.Bedtime != nil {
+		form.Set("bedtime", g.Bedtime.String())
+	}
+	if g.WakeupTime != nil {
+		form.Set("wakeupTime", g.WakeupTime.String())
+	}
+	saved := SleepGoal{}
+	if err := c.sendInto("POST", apiURL("1.2/user/-/sleep/goal.json"), form, &saved); err != nil {
+		return saved, err
+	}
+	return saved, nil
+}
+
+// SleepGoalResult is the outcome of a session of sleep measured against
+// a sleep goal. Deviations are positive when later than the target, and
+// are nil if the goal has no such target.
+type SleepGoalResult struct {
+	DateOfSleep      time.Time
+	IsPrimary        bool
+	Met              bool
+	Shortfall        time.Duration
+	BedtimeDeviation *time.Duration
+	WakeupDeviation  *time.Duration
+}
+
+// Evaluate measures a session against the goal, where the goal is met if
+// the time asleep was at least the minimum duration of the goal.
+func (g SleepGoal) Evaluate(s Session) SleepGoalResult {
+	r := SleepGoalResult{
+		DateOfSleep: s.DateOfSleep,
+		IsPrimary:   s.IsPrimary,
+		Met:         s.Asleep >= g.MinDuration,
+	}
+	if !r.Met {
+		r.Shortfall = g.MinDuration - s.Asleep
+	}
+	if g.Bedtime != nil {
+		d := s.Start.Sub(g.Bedtime.nearest(s.Start))
+		r.BedtimeDeviation = &d
+	}
+	if g.WakeupTime != nil {
+		d := s.End.Sub(g.WakeupTime.nearest(s.End))
+		r.WakeupDeviation = &d
+	}
+	return r
+}
+
+// EvaluateSleepLog measures each session of the sleep log against the goal.
+func (g SleepGoal) EvaluateSleepLog(l *SleepLog) []SleepGoalResult {
+	a := make([]SleepGoalResult, len(l.Sessions))
+	for i, s := range l.Sessions {
+		a[i] = g.Evaluate(s)
+	}
+	return a
+}
@@ -0,0 +1,56 @@
+package bitfit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestEvaluatingSleepGoal(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/sleep_log_payload_20190916.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := new(SleepLog)
+	if err := json.Unmarshal(b, s); err != nil {
+		t.Fatal(err)
+	}
+	b = []byte(`{
+		"consistency": {"awakeRestlessPercentage": 0.08, "recommendedSleepGoal": 450, "typicalDuration": 421, "typicalWakeupTime": "08:30"},
+		"goal": {"bedtime": "23:00", "minDuration": 420, "updatedOn": "2019-09-10T20:15:31.000", "wakeupTime": "07:00"}
+	}`)
+	g := new(SleepGoal)
+	if err := json.Unmarshal(b, g); err != nil {
+		t.Fatal(err)
+	}
+	errFmt := "expected %v but received %v"
+	if e, a := 7*time.Hour, g.MinDuration; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := "08:30", g.Consistency.TypicalWakeupTime.String(); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	r := g.EvaluateSleepLog(s)
+	if e, a := 1, len(r); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if r[0].Met {
+		t.Fatalf("expected sleep goal to be missed")
+	}
+	if e, a := 61*time.Minute, r[0].Shortfall; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := 3*time.Hour+8*time.Minute, *r[0].BedtimeDeviation; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := 2*time.Hour+9*time.Minute+30*time.Second, *r[0].WakeupDeviation; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	g.MinDuration = 5 * time.Hour
+	g.Bedtime, g.WakeupTime = nil, nil
+	rr := g.Evaluate(s.Sessions[0])
+	if !rr.Met || rr.Shortfall != 0 || rr.BedtimeDeviation != nil {
+		t.Fatalf("expected sleep goal to be met without deviations but received %+v", rr)
+	}
+}
@@ -0,0 +1,383 @@
+// Package sleepsync downloads sleep logs as Fitbit notifies of changes
+// to them (see the webhook package), rather than by polling for them.
+// Notifications are queued durably, such that those received while the
+// Fitbit API could not be reached (or rate-limited requests) are retried.
+package sleepsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aoeu/bitfit"
+	"github.com/aoeu/bitfit/webhook"
+)
+
+var dateFmt = "2006-01-02"
+
+// Job is the download of the sleep log of an owner (user) on a date.
+type Job struct {
+	OwnerID   string
+	Date      time.Time
+	Attempts  uint
+	NotBefore time.Time
+	// Notified counts the notifications the job was pushed for.
+	Notified uint
+}
+
+func (j Job) key() string {
+	return j.OwnerID + "/" + j.Date.Format(dateFmt)
+}
+
+// Queue is a queue of jobs that is saved to a file on every change, and
+// that holds at most one pending job per owner and date.
+type Queue struct {
+	mu       sync.Mutex
+	filepath string
+	jobs     []Job
+	pushed   chan struct{}
+}
+
+// OpenQueue loads the queue saved at filepath, or starts an empty queue
+// if no such file exists yet.
+func OpenQueue(filepath string) (*Queue, error) {
+	q := &Queue{
+		filepath: filepath,
+		jobs:     make([]Job, 0),
+		pushed:   make(chan struct{}, 1),
+	}
+	b, err := ioutil.ReadFile(filepath)
+	switch {
+	case os.IsNotExist(err):
+		return q, nil
+	case err != nil:
+		return nil, fmt.Errorf("could not read queue at filepath '%v': %v", filepath, err)
+	}
+	if err := json.Unmarshal(b, &q.jobs); err != nil {
+		return nil, fmt.Errorf("could not unmarshal queue at filepath '%v': %v", filepath, err)
+	}
+	return q, nil
+}
+
+// Push adds a job to the queue unless a job of the same owner and date
+// is already pending, and reports whether it was added. A pending job is
+// only counted as notified again, so that if it is already running it is
+// not removed from the queue once done (see Done).
+func (q *Queue) Push(j Job) (added bool, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, jj := range q.jobs {
+		if jj.key() == j.key() {
+			q.jobs[i].Notified++
+			if err := q.save(); err != nil {
+				q.jobs[i].Notified--
+				return false, err
+			}
+			return false, nil
+		}
+	}
+	j.Notified = 1
+	q.jobs = append(q.jobs, j)
+	if err := q.save(); err != nil {
+		q.jobs = q.jobs[:len(q.jobs)-1]
+		return false, err
+	}
+	select {
+	case q.pushed <- struct{}{}:
+	default:
+	}
+	return true, nil
+}
+
+// Next returns the job that has waited longest among those that are not
+// waiting to be retried.
+func (q *Queue) Next(now time.Time) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, j := range q.jobs {
+		if !j.NotBefore.After(now) {
+			return j, true
+		}
+	}
+	return Job{}, false
+}
+
+// Done removes a job from the queue, unless it was notified of again
+// since it was returned by Next, in which case it is left to run again.
+func (q *Queue) Done(j Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, jj := range q.jobs {
+		if jj.key() == j.key() && jj.Notified != j.Notified {
+			jj.Attempts, jj.NotBefore = 0, time.Time{}
+			return q.replace(j, &jj)
+		}
+	}
+	return q.replace(j, nil)
+}
+
+// Retry postpones a job until after a delay and counts the attempt.
+func (q *Queue) Retry(j Job, delay time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, jj := range q.jobs {
+		if jj.key() == j.key() {
+			j.Notified = jj.Notified
+		}
+	}
+	j.Attempts++
+	j.NotBefore = time.Now().Add(delay)
+	return q.replace(j, &j)
+}
+
+// replace removes the job of the same key as j, appending with if set.
+func (q *Queue) replace(j Job, with *Job) error {
+	a := make([]Job, 0, len(q.jobs))
+	for _, jj := range q.jobs {
+		if jj.key() != j.key() {
+			a = append(a, jj)
+		}
+	}
+	if with != nil {
+		a = append(a, *with)
+	}
+	prev := q.jobs
+	q.jobs = a
+	if err := q.save(); err != nil {
+		q.jobs = prev
+		return err
+	}
+	return nil
+}
+
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.jobs)
+}
+
+// Pending returns the jobs in the queue in order of when they are ready.
+func (q *Queue) Pending() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	a := append([]Job{}, q.jobs...)
+	sort.SliceStable(a, func(i, j int) bool { return a[i].NotBefore.Before(a[j].NotBefore) })
+	return a
+}
+
+// save writes the queue to a temporary file and renames it over the last
+// saved queue, so that the queue is never partially written.
+func (q *Queue) save() error {
+	b, err := json.MarshalIndent(q.jobs, "", "    ")
+	if err != nil {
+		return fmt.Errorf("could not serialize queue: %v", err)
+	}
+	tmp := q.filepath + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return fmt.Errorf("could not save queue to file '%v': %v", tmp, err)
+	}
+	if err := os.Rename(tmp, q.filepath); err != nil {
+		return fmt.Errorf("could not save queue to file '%v': %v", q.filepath, err)
+	}
+	return nil
+}
+
+// Store persists the payload of an owner's sleep log of a date.
+type Store interface {
+	Save(ownerID string, date time.Time, payload []byte) error
+}
+
+// DirStore saves each sleep log to a file in a directory per owner, named
+// as the dlsleeplog command names them (e.g. sleep_log_payload_2019-09-16.json).
+type DirStore struct {
+	Dir string
+	As  string
+}
+
+func (d DirStore) Save(ownerID string, date time.Time, payload []byte) error {
+	dir := filepath.Join(d.Dir, filepath.Base(ownerID))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("could not make directory '%v': %v", dir, err)
+	}
+	as := d.As
+	if as == "" {
+		as = "sleep_log_payload"
+	}
+	s := filepath.Join(dir, fmt.Sprintf("%v_%v.json", as, date.Format(dateFmt)))
+	if err := ioutil.WriteFile(s, payload, 0644); err != nil {
+		return fmt.Errorf("could not write to file '%v': %v", s, err)
+	}
+	return nil
+}
+
+// Syncer queues a job for each notification of a change to sleep logs and
+// runs each job by fetching the sleep log and saving it to the store.
+type Syncer struct {
+	*Queue
+	Store
+	// Fetch fetches the sleep log of the owner on the date, such as with
+	// the FetchSleepLog method of the owner's bitfit.Client.
+	Fetch func(ownerID string, date time.Time) ([]byte, error)
+	// Backoff is the delay before the first retry of a failed job, which
+	// doubles on each further attempt up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// PollInterval is the longest the Syncer waits to check for jobs that
+	// are ready to be retried.
+	PollInterval time.Duration
+	// MaxAttempts is the number of attempts after which a failing job is
+	// dropped, such as one of an owner who revoked access, or 0 to retry
+	// failing jobs until they succeed.
+	MaxAttempts uint
+}
+
+// ErrUnknownOwner is returned by a Fetch func for an owner it has no tokens
+// of, whose job is dropped rather than retried.
+var ErrUnknownOwner = errors.New("no tokens of the owner are known")
+
+// retryable reports whether a job that failed with the error could succeed
+// if retried, which it cannot if the owner is unknown or the owner's tokens
+// were not granted the scope to read sleep logs.
+func retryable(err error) bool {
+	var e *bitfit.InsufficientScopeError
+	return err != ErrUnknownOwner && !errors.As(err, &e)
+}
+
+// ClientFetch returns a Fetch func that fetches sleep logs with the client,
+// of the owner of its tokens only, so that the sleep log of the owner of
+// the tokens is not saved as that of another owner.
+func ClientFetch(c *bitfit.Client) func(ownerID string, date time.Time) ([]byte, error) {
+	return func(ownerID string, date time.Time) ([]byte, error) {
+		if ownerID != c.CurrentTokens().UserID {
+			return nil, ErrUnknownOwner
+		}
+		return c.FetchSleepLog(date)
+	}
+}
+
+// ManagerFetch returns a Fetch func that fetches the sleep log of each
+// owner with the owner's client of the manager.
+func ManagerFetch(m *bitfit.Manager) func(ownerID string, date time.Time) ([]byte, error) {
+	return func(ownerID string, date time.Time) ([]byte, error) {
+		ok, err := m.Has(ownerID)
+		switch {
+		case err != nil:
+			return nil, err
+		case !ok:
+			return nil, ErrUnknownOwner
+		}
+		c, err := m.Client(ownerID)
+		if err != nil {
+			return nil, err
+		}
+		return c.FetchSleepLog(date)
+	}
+}
+
+func NewSyncer(q *Queue, s Store, fetch func(ownerID string, date time.Time) ([]byte, error)) *Syncer {
+	return &Syncer{
+		Queue:        q,
+		Store:        s,
+		Fetch:        fetch,
+		Backoff:      time.Minute,
+		MaxBackoff:   time.Hour,
+		PollInterval: 30 * time.Second,
+		MaxAttempts:  24,
+	}
+}
+
+// Notify queues a job for a notification of a change to a sleep log, and
+// ignores notifications of any other collection or without a date. It may
+// be used as the Notify func of a webhook.Handler.
+func (s *Syncer) Notify(n webhook.Notification) error {
+	if n.Collection != bitfit.Sleep {
+		return nil
+	}
+	if n.Date.IsZero() {
+		log.Printf("ignoring notification of sleep log of %v without a date", n.OwnerID)
+		return nil
+	}
+	_, err := s.Push(Job{OwnerID: n.OwnerID, Date: n.Date})
+	return err
+}
+
+// Run runs jobs as they are ready until the context is done.
+func (s *Syncer) Run(ctx context.Context) {
+	t := time.NewTicker(s.PollInterval)
+	defer t.Stop()
+	for {
+		for s.RunNext() {
+			if ctx.Err() != nil {
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.pushed:
+		case <-t.C:
+		}
+	}
+}
+
+// RunNext runs the next job that is ready, if any, and reports whether
+// there was one and the queue could be updated to account for it.
+func (s *Syncer) RunNext() bool {
+	j, ok := s.Next(time.Now())
+	if !ok {
+		return false
+	}
+	err := s.run(j)
+	switch {
+	case err == nil:
+	case !retryable(err):
+		log.Printf("dropping sync of sleep log of %v: %v", j.key(), err)
+		err = nil
+	case s.MaxAttempts > 0 && j.Attempts+1 >= s.MaxAttempts:
+		ss := "dropping sync of sleep log of %v after %d attempts: %v"
+		log.Printf(ss, j.key(), j.Attempts+1, err)
+		err = nil
+	}
+	if err != nil {
+		d := s.backoff(j.Attempts)
+		ss := "could not sync sleep log of %v (attempt %d), retrying in %v: %v"
+		log.Printf(ss, j.key(), j.Attempts+1, d, err)
+		if err := s.Retry(j, d); err != nil {
+			log.Println(err)
+			return false
+		}
+		return true
+	}
+	if err := s.Done(j); err != nil {
+		log.Println(err)
+		return false
+	}
+	return true
+}
+
+func (s *Syncer) run(j Job) error {
+	b, err := s.Fetch(j.OwnerID, j.Date)
+	if err != nil {
+		return err
+	}
+	return s.Save(j.OwnerID, j.Date, b)
+}
+
+func (s *Syncer) backoff(attempts uint) time.Duration {
+	d := s.Backoff
+	for i := uint(0); i < attempts && d < s.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > s.MaxBackoff {
+		d = s.MaxBackoff
+	}
+	return d
+}
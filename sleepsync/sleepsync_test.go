@@ -0,0 +1,240 @@
+package sleepsync
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aoeu/bitfit"
+	"github.com/aoeu/bitfit/webhook"
+)
+
+func TestSyncingNotifiedSleepLogs(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	dir := t.TempDir()
+	qf := filepath.Join(dir, "queue.json")
+	q, err := OpenQueue(qf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetches, fail := 0, true
+	s := NewSyncer(q, DirStore{Dir: dir}, func(ownerID string, date time.Time) ([]byte, error) {
+		fetches++
+		if fail {
+			return nil, errors.New("too many requests")
+		}
+		return []byte(`{"sleep": []}`), nil
+	})
+	d := time.Date(2019, 9, 16, 0, 0, 0, 0, time.UTC)
+	for _, n := range []webhook.Notification{
+		{Collection: bitfit.Sleep, Date: d, OwnerID: "BAZ"},
+		{Collection: bitfit.Sleep, Date: d, OwnerID: "BAZ"},
+		{Collection: bitfit.Foods, Date: d, OwnerID: "BAZ"},
+	} {
+		if err := s.Notify(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errFmt := "expected %v but received %v"
+	if e, a := 1, q.Len(); e != a {
+		t.Fatalf("expected %v job after deduplication but there were %v", e, a)
+	}
+
+	if !s.RunNext() {
+		t.Fatal("expected a job to be run")
+	}
+	if s.RunNext() {
+		t.Fatal("expected the failed job to wait to be retried")
+	}
+	// Reopening the queue stands in for restarting after downtime.
+	if q, err = OpenQueue(qf); err != nil {
+		t.Fatal(err)
+	}
+	j := q.Pending()
+	if e, a := 1, len(j); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := uint(1), j[0].Attempts; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if !j[0].NotBefore.After(time.Now()) {
+		t.Fatalf("expected the retry of the job to be delayed but it is ready at %v", j[0].NotBefore)
+	}
+
+	j[0].NotBefore = time.Time{}
+	if err := q.replace(j[0], &j[0]); err != nil {
+		t.Fatal(err)
+	}
+	s.Queue, fail = q, false
+	if !s.RunNext() {
+		t.Fatal("expected a job to be run")
+	}
+	if e, a := 0, q.Len(); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := 2, fetches; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "BAZ", "sleep_log_payload_2019-09-16.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, a := `{"sleep": []}`, string(b); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+}
+
+func TestDroppingJobsThatCannotSucceed(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	q, err := OpenQueue(filepath.Join(t.TempDir(), "queue.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetches := make(map[string]int)
+	s := NewSyncer(q, DirStore{Dir: t.TempDir()}, func(ownerID string, date time.Time) ([]byte, error) {
+		fetches[ownerID]++
+		if ownerID == "BAZ" {
+			return nil, &bitfit.InsufficientScopeError{Required: "sleep", Granted: []string{"profile"}}
+		}
+		return nil, errors.New("Refresh token invalid: bar")
+	})
+	s.MaxAttempts = 3
+	d := time.Date(2019, 9, 16, 0, 0, 0, 0, time.UTC)
+	for _, n := range []webhook.Notification{
+		{Collection: bitfit.Sleep, Date: d, OwnerID: "BAZ"},
+		{Collection: bitfit.Sleep, Date: d, OwnerID: "QUX"},
+		{Collection: bitfit.Sleep, OwnerID: "CORGE"},
+	} {
+		if err := s.Notify(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errFmt := "expected %v but received %v"
+	if e, a := 2, q.Len(); e != a {
+		t.Fatalf("expected %v jobs without the dateless notification but there were %v", e, a)
+	}
+	for i := 0; i < 10 && q.Len() > 0; i++ {
+		// Each job is made ready as if its retry were due.
+		for _, j := range q.Pending() {
+			j.NotBefore = time.Time{}
+			if err := q.replace(j, &j); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for s.RunNext() {
+		}
+	}
+	if e, a := 0, q.Len(); e != a {
+		t.Fatalf("expected the failing jobs to be dropped but %v remain", a)
+	}
+	for id, e := range map[string]int{"BAZ": 1, "QUX": 3, "CORGE": 0} {
+		if a := fetches[id]; e != a {
+			t.Fatalf(errFmt, e, a)
+		}
+	}
+}
+
+func TestRequeuingJobNotifiedWhileRunning(t *testing.T) {
+	dir := t.TempDir()
+	q, err := OpenQueue(filepath.Join(dir, "queue.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := time.Date(2019, 9, 16, 0, 0, 0, 0, time.UTC)
+	if _, err := q.Push(Job{OwnerID: "BAZ", Date: d}); err != nil {
+		t.Fatal(err)
+	}
+	j, _ := q.Next(time.Now())
+	if added, err := q.Push(Job{OwnerID: "BAZ", Date: d}); err != nil || added {
+		t.Fatalf("expected the job to be deduplicated but added was %v with error %v", added, err)
+	}
+	if err := q.Done(j); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := 1, q.Len(); e != a {
+		t.Fatalf("expected %v job to remain to be run again but there were %v", e, a)
+	}
+}
+
+func TestSyncingSleepLogsOfEachOwner(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"authorization": %q}`, r.Header.Get("Authorization"))
+	}))
+	prev := bitfit.BaseURL
+	bitfit.BaseURL = srv.URL
+	t.Cleanup(func() {
+		bitfit.BaseURL = prev
+		srv.Close()
+	})
+	dir := t.TempDir()
+	store := bitfit.DirTokenStore(t.TempDir())
+	exp := time.Now().Add(time.Hour)
+	for _, id := range []string{"BAZ", "QUX"} {
+		if err := store.Save(id, bitfit.Tokens{Access: id + "_TOKEN", Refresh: "bar", Expiration: exp, UserID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := bitfit.NewStoreClient("id", "secret", "BAZ", store)
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+	d := time.Date(2019, 9, 16, 0, 0, 0, 0, time.UTC)
+	notifications := []webhook.Notification{
+		{Collection: bitfit.Sleep, Date: d, OwnerID: "BAZ"},
+		{Collection: bitfit.Sleep, Date: d, OwnerID: "QUX"},
+		{Collection: bitfit.Sleep, Date: d, OwnerID: "CORGE"},
+	}
+	errFmt := "expected %v but received %v"
+	tests := []struct {
+		fetch func(ownerID string, date time.Time) ([]byte, error)
+		saved map[string]string
+	}{
+		{
+			ManagerFetch(bitfit.NewManager("id", "secret", store)),
+			map[string]string{"BAZ": "Bearer BAZ_TOKEN", "QUX": "Bearer QUX_TOKEN"},
+		},
+		{
+			// A single client only syncs the sleep logs of its own owner.
+			ClientFetch(c),
+			map[string]string{"BAZ": "Bearer BAZ_TOKEN"},
+		},
+	}
+	for i, tt := range tests {
+		into := filepath.Join(dir, fmt.Sprint(i))
+		q, err := OpenQueue(filepath.Join(dir, fmt.Sprintf("queue_%d.json", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := NewSyncer(q, DirStore{Dir: into}, tt.fetch)
+		for _, n := range notifications {
+			if err := s.Notify(n); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for s.RunNext() {
+		}
+		if e, a := 0, q.Len(); e != a {
+			t.Fatalf("expected the jobs of unknown owners to be dropped but %v remain", a)
+		}
+		for _, id := range []string{"BAZ", "QUX", "CORGE"} {
+			b, err := ioutil.ReadFile(filepath.Join(into, id, "sleep_log_payload_2019-09-16.json"))
+			switch e, ok := tt.saved[id]; {
+			case !ok && !os.IsNotExist(err):
+				t.Fatalf("expected no sleep log of %v to be saved but received %s (%v)", id, b, err)
+			case ok && err != nil:
+				t.Fatal(err)
+			case ok && !strings.Contains(string(b), e):
+				t.Fatalf(errFmt, e, string(b))
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package bitfit
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// Collection is a kind of data that the Subscription API notifies of
+// changes to. The empty collection subscribes to all of them.
+type Collection string
+
+const (
+	AllCollections    Collection = ""
+	Activities        Collection = "activities"
+	Body              Collection = "body"
+	Foods             Collection = "foods"
+	Sleep             Collection = "sleep"
+	UserRevokedAccess Collection = "userRevokedAccess"
+)
+
+// path returns the collection as the segment of a URL path preceding
+// that of the subscriptions resource.
+func (c Collection) path() string {
+	if c == AllCollections {
+		return ""
+	}
+	return string(c) + "/"
+}
+
+type Subscription struct {
+	ID           string
+	Collection   Collection
+	OwnerID      string
+	OwnerType    string
+	SubscriberID string
+}
+
+func (s *Subscription) UnmarshalJSON(data []byte) error {
+	j := struct {
+		SubscriptionID string
+		CollectionType Collection
+		OwnerID        string
+		OwnerType      string
+		SubscriberID   string
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	s.ID = j.SubscriptionID
+	s.Collection = j.CollectionType
+	s.OwnerID = j.OwnerID
+	s.OwnerType = j.OwnerType
+	s.SubscriberID = j.SubscriberID
+	return nil
+}
+
+// CreateSubscription subscribes to notifications of changes to the
+// collection, identified by the ID that notifications will refer to.
+// The subscriber ID may be empty to use the default subscriber of the
+// application, as configured at https://dev.fitbit.com/apps.
+func (c *Client) CreateSubscription(col Collection, id, subscriberID string) (Subscription, error) {
+	s := apiURL("1/user/-/%vapiSubscriptions/%v.json")
+	req, err := newFormRequest("POST", fmt.Sprintf(s, col.path(), url.PathEscape(id)), nil)
+	if err != nil {
+		return Subscription{}, err
+	}
+	if subscriberID != "" {
+		req.Header.Add("X-Fitbit-Subscriber-Id", subscriberID)
+	}
+	b, err := c.do(req)
+	if err != nil {
+		return Subscription{}, err
+	}
+	sub := Subscription{}
+	if err := json.Unmarshal(b, &sub); err != nil {
+		return sub, fmt.Errorf("could not unmarshal subscription: %v", err)
+	}
+	return sub, nil
+}
+
+func (c *Client) FetchSubscriptions(col Collection) ([]Subscription, error) {
+	s := apiURL("1/user/-/%vapiSubscriptions.json")
+	j := struct {
+		APISubscriptions []Subscription
+	}{}
+	if err := c.fetchInto(fmt.Sprintf(s, col.path()), &j); err != nil {
+		return nil, err
+	}
+	return j.APISubscriptions, nil
+}
+
+func (c *Client) DeleteSubscription(col Collection, id string) error {
+	s := apiURL("1/user/-/%vapiSubscriptions/%v.json")
+	_, err := c.send("DELETE", fmt.Sprintf(s, col.path(), url.PathEscape(id)), nil)
+	return err
+}
@@ -0,0 +1,201 @@
+// Package webhook receives notifications from the Fitbit Subscription API,
+// which are sent to a subscriber endpoint whenever the data of a collection
+// that a user is subscribed to changes (see bitfit.Client.CreateSubscription).
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aoeu/bitfit"
+)
+
+// SignatureHeader is the header of a notification that holds its signature.
+const SignatureHeader = "X-Fitbit-Signature"
+
+// maxBodySize bounds the size of a request body read by the Handler, well
+// above that of the largest batch of notifications Fitbit would send.
+const maxBodySize = 1 << 20
+
+var dateFmt = "2006-01-02"
+
+type Notification struct {
+	Collection     bitfit.Collection
+	Date           time.Time
+	OwnerID        string
+	OwnerType      string
+	SubscriptionID string
+}
+
+func (n *Notification) UnmarshalJSON(data []byte) error {
+	j := struct {
+		CollectionType bitfit.Collection
+		Date           string
+		OwnerID        string
+		OwnerType      string
+		SubscriptionID string
+	}{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	n.Collection = j.CollectionType
+	n.OwnerID = j.OwnerID
+	n.OwnerType = j.OwnerType
+	n.SubscriptionID = j.SubscriptionID
+	if j.Date == "" {
+		return nil
+	}
+	var err error
+	n.Date, err = time.Parse(dateFmt, j.Date)
+	return err
+}
+
+// MarshalJSON serializes the notification as Fitbit sends it.
+func (n Notification) MarshalJSON() ([]byte, error) {
+	d := ""
+	if !n.Date.IsZero() {
+		d = n.Date.Format(dateFmt)
+	}
+	return json.Marshal(&struct {
+		CollectionType bitfit.Collection `json:"collectionType"`
+		Date           string            `json:"date,omitempty"`
+		OwnerID        string            `json:"ownerId"`
+		OwnerType      string            `json:"ownerType"`
+		SubscriptionID string            `json:"subscriptionId"`
+	}{
+		n.Collection,
+		d,
+		n.OwnerID,
+		n.OwnerType,
+		n.SubscriptionID,
+	})
+}
+
+// Sign returns the signature of a notification's body, as the base64
+// encoding of the HMAC-SHA1 of it keyed by the client secret and an
+// ampersand.
+func Sign(clientSecret string, body []byte) string {
+	m := hmac.New(sha1.New, []byte(clientSecret+"&"))
+	m.Write(body)
+	return base64.StdEncoding.EncodeToString(m.Sum(nil))
+}
+
+// Handler answers the verification of a subscriber endpoint and passes
+// each notification that is validly signed to the Notify func.
+type Handler struct {
+	// VerificationCode is the code shown for the subscriber at
+	// https://dev.fitbit.com/apps, with which Fitbit verifies an endpoint.
+	VerificationCode string
+	// ClientSecret is the OAuth2 client secret of the application, without
+	// which no notification is accepted, since none could be verified.
+	ClientSecret string
+	// Notify is called with each notification, and should return quickly
+	// since Fitbit expects a response to notifications within 5 seconds.
+	// Any error returned is responded with as an internal server error,
+	// upon which Fitbit sends the whole batch of notifications again, so
+	// Notify must be idempotent for those it already handled.
+	// Notifications are acknowledged but otherwise ignored if it is nil.
+	Notify func(Notification) error
+}
+
+func NewHandler(verificationCode, clientSecret string, notify func(Notification) error) *Handler {
+	return &Handler{
+		VerificationCode: verificationCode,
+		ClientSecret:     clientSecret,
+		Notify:           notify,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		h.verify(w, r)
+	case "POST":
+		h.notify(w, r)
+	default:
+		s := fmt.Sprintf("method %v is not allowed", r.Method)
+		writeResp(w, http.StatusMethodNotAllowed, s)
+	}
+}
+
+// verify responds as Fitbit requires when verifying the endpoint, i.e.
+// with No Content when the code is correct and Not Found when it is not.
+func (h *Handler) verify(w http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("verify")
+	switch {
+	case code == "":
+		writeResp(w, http.StatusBadRequest, "a verification code is required")
+	case !hmac.Equal([]byte(code), []byte(h.VerificationCode)):
+		writeResp(w, http.StatusNotFound, "incorrect verification code")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *Handler) notify(w http.ResponseWriter, r *http.Request) {
+	if h.ClientSecret == "" {
+		writeResp(w, http.StatusInternalServerError, "no client secret is configured to verify notifications with")
+		return
+	}
+	b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		s := fmt.Sprintf("could not read notifications: %v", err)
+		writeResp(w, http.StatusBadRequest, s)
+		return
+	}
+	sig := r.Header.Get(SignatureHeader)
+	if sig == "" || !hmac.Equal([]byte(sig), []byte(Sign(h.ClientSecret, b))) {
+		// Fitbit expects an invalid signature to be responded to as Not Found.
+		writeResp(w, http.StatusNotFound, "missing or incorrect signature of notifications")
+		return
+	}
+	var a []Notification
+	if err := json.Unmarshal(b, &a); err != nil {
+		s := fmt.Sprintf("could not unmarshal notifications: %v", err)
+		writeResp(w, http.StatusBadRequest, s)
+		return
+	}
+	for _, n := range a {
+		if h.Notify == nil {
+			continue
+		}
+		if err := h.Notify(n); err != nil {
+			s := fmt.Sprintf("could not handle notification %+v: %v", n, err)
+			writeResp(w, http.StatusInternalServerError, s)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeResp(w http.ResponseWriter, code int, message string) {
+	log.Println(message)
+	w.WriteHeader(code)
+	if _, err := w.Write([]byte(message)); err != nil {
+		log.Printf("could not write '%v' as response with HTTP Status '%v': %v", message, code, err)
+	}
+}
+
+// Post signs and sends notifications to a subscriber endpoint at url just
+// as Fitbit would, to stand in for Fitbit when testing an endpoint.
+func Post(url, clientSecret string, n ...Notification) (*http.Response, error) {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal notifications: %v", err)
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add(SignatureHeader, Sign(clientSecret, b))
+	return http.DefaultClient.Do(req)
+}
@@ -0,0 +1,140 @@
+package webhook
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aoeu/bitfit"
+)
+
+func TestVerifyingEndpoint(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	srv := httptest.NewServer(NewHandler("code", "secret", nil))
+	defer srv.Close()
+	for q, e := range map[string]int{
+		"?verify=code":  http.StatusNoContent,
+		"?verify=wrong": http.StatusNotFound,
+		"":              http.StatusBadRequest,
+	} {
+		resp, err := http.Get(srv.URL + q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if a := resp.StatusCode; e != a {
+			t.Fatalf("expected status %v verifying with '%v' but received %v", e, q, a)
+		}
+	}
+}
+
+func TestReceivingSignedNotifications(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	var received []Notification
+	fail := false
+	srv := httptest.NewServer(NewHandler("code", "secret", func(n Notification) error {
+		if fail {
+			return errors.New("could not handle")
+		}
+		received = append(received, n)
+		return nil
+	}))
+	defer srv.Close()
+
+	d := time.Date(2019, 9, 16, 0, 0, 0, 0, time.UTC)
+	n := []Notification{
+		{Collection: bitfit.Sleep, Date: d, OwnerID: "BAZ", OwnerType: "user", SubscriptionID: "1"},
+		{Collection: bitfit.Foods, Date: d, OwnerID: "BAZ", OwnerType: "user", SubscriptionID: "1"},
+	}
+	errFmt := "expected %v but received %v"
+	resp, err := Post(srv.URL, "secret", n...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if e, a := http.StatusNoContent, resp.StatusCode; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := 2, len(received); e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := n[0], received[0]; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+
+	resp, err = Post(srv.URL, "not the secret", n...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if e, a := http.StatusNotFound, resp.StatusCode; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	resp, err = http.Post(srv.URL, "application/json", bytes.NewReader([]byte(`[]`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if e, a := http.StatusNotFound, resp.StatusCode; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := 2, len(received); e != a {
+		t.Fatalf("expected unsigned notifications to be ignored but %v were received", a-e)
+	}
+
+	fail = true
+	resp, err = Post(srv.URL, "secret", n...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if e, a := http.StatusInternalServerError, resp.StatusCode; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+}
+
+func TestIgnoringNotificationsWithoutNotify(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	for _, h := range []*Handler{NewHandler("code", "secret", nil), {ClientSecret: "secret"}} {
+		srv := httptest.NewServer(h)
+		n := Notification{Collection: bitfit.Sleep, Date: time.Date(2019, 9, 16, 0, 0, 0, 0, time.UTC), OwnerID: "BAZ"}
+		resp, err := Post(srv.URL, "secret", n)
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if e, a := http.StatusNoContent, resp.StatusCode; e != a {
+			t.Fatalf("expected %v but received %v", e, a)
+		}
+	}
+}
+
+func TestRejectingNotificationsWithoutClientSecret(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	received := 0
+	srv := httptest.NewServer(NewHandler("code", "", func(n Notification) error {
+		received++
+		return nil
+	}))
+	defer srv.Close()
+	// Signed with an empty secret, as anyone could sign notifications.
+	n := Notification{Collection: bitfit.Sleep, Date: time.Date(2019, 9, 16, 0, 0, 0, 0, time.UTC), OwnerID: "BAZ"}
+	resp, err := Post(srv.URL, "", n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	errFmt := "expected %v but received %v"
+	if e, a := http.StatusInternalServerError, resp.StatusCode; e != a {
+		t.Fatalf(errFmt, e, a)
+	}
+	if e, a := 0, received; e != a {
+		t.Fatalf("expected no notifications to be dispatched but %v were", a)
+	}
+}